@@ -0,0 +1,11 @@
+package antientropy
+
+import "errors"
+
+// The errors returned when a peer doesn't follow the protocol.
+var (
+	ErrMalformedMessage  = errors.New("antientropy: malformed message")
+	ErrFrameTooLarge     = errors.New("antientropy: message exceeds the size limit")
+	ErrUnexpectedMessage = errors.New("antientropy: unexpected message")
+	ErrVersionMismatch   = errors.New("antientropy: peer uses a different protocol version")
+)
@@ -0,0 +1,153 @@
+package antientropy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"sort"
+
+	"github.com/dogenkigen/merkletree"
+	"github.com/dogenkigen/merkletree/antientropy"
+)
+
+// buckets is the number of leaves of the trees of the replicas. Every key belongs to one of them,
+// so the trees of all replicas have the same size regardless of the keys they hold.
+const buckets = 64
+
+type entry struct {
+	Value   string
+	Version int
+}
+
+// replica is a key-value store replica which keeps the newest version of every key.
+type replica struct {
+	data map[string]entry
+}
+
+func bucketOf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % buckets)
+}
+
+// tree builds a Merkle tree with a leaf for every bucket holding its sorted entries.
+func (r *replica) tree() *merkletree.MerkleTree {
+	contents := make([]bytes.Buffer, buckets)
+	keys := make([]string, 0, len(r.data))
+	for key := range r.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e := r.data[key]
+		fmt.Fprintf(&contents[bucketOf(key)], "%q=%q@%d\n", key, e.Value, e.Version)
+	}
+	leaves := make([]*merkletree.Leaf, buckets)
+	for i := range leaves {
+		leaves[i] = merkletree.NewLeaf(contents[i].Bytes())
+	}
+	tree, _ := merkletree.NewMerkleTree(leaves, merkletree.SHA256Hasher)
+	return tree
+}
+
+// entries returns the entries of the given buckets.
+func (r *replica) entries(buckets []int) map[string]entry {
+	wanted := make(map[int]bool)
+	for _, b := range buckets {
+		wanted[b] = true
+	}
+	entries := make(map[string]entry)
+	for key, e := range r.data {
+		if wanted[bucketOf(key)] {
+			entries[key] = e
+		}
+	}
+	return entries
+}
+
+// merge stores the entries which are newer than the local ones.
+func (r *replica) merge(entries map[string]entry) {
+	for key, e := range entries {
+		if local, ok := r.data[key]; !ok || e.Version > local.Version {
+			r.data[key] = e
+		}
+	}
+}
+
+// repair finds the buckets in which the replicas differ and exchanges their entries.
+// The initiator sends its entries first and the responder replies with its own ones.
+func (r *replica) repair(conn io.ReadWriter, role antientropy.Role) ([]int, error) {
+	result, err := antientropy.Sync(conn, r.tree(), role)
+	if err != nil {
+		return nil, err
+	}
+	// both replicas have the same number of buckets, so all the differing ones are present in both trees
+	diff := result.Differ
+	if len(diff) == 0 {
+		return diff, nil
+	}
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+	var peerEntries map[string]entry
+	if role == antientropy.Initiator {
+		if err := enc.Encode(r.entries(diff)); err != nil {
+			return nil, err
+		}
+		err = dec.Decode(&peerEntries)
+	} else {
+		if err = dec.Decode(&peerEntries); err == nil {
+			err = enc.Encode(r.entries(diff))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.merge(peerEntries)
+	return diff, nil
+}
+
+// Example_keyValueReplicas reconciles two replicas of a key-value store like Dynamo and Cassandra do.
+// The keys are grouped into buckets, the replicas find the buckets which differ with the anti-entropy protocol
+// and only the entries of those buckets are exchanged.
+func Example_keyValueReplicas() {
+	a := &replica{data: map[string]entry{}}
+	b := &replica{data: map[string]entry{}}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		a.data[key] = entry{Value: "initial", Version: 1}
+		b.data[key] = entry{Value: "initial", Version: 1}
+	}
+	// the replicas missed some of each other's writes
+	a.data["key-7"] = entry{Value: "written to a", Version: 2}
+	b.data["key-42"] = entry{Value: "written to b", Version: 2}
+	b.data["key-1000"] = entry{Value: "new in b", Version: 1}
+
+	left, right := net.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := b.repair(right, antientropy.Responder)
+		done <- err
+	}()
+	diff, err := a.repair(left, antientropy.Initiator)
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("differing buckets:", len(diff), "of", buckets)
+	fmt.Println("replicas equal:", bytes.Equal(a.tree().Hash(), b.tree().Hash()))
+	fmt.Println("key-7 in b:", b.data["key-7"].Value)
+	fmt.Println("key-42 in a:", a.data["key-42"].Value)
+	fmt.Println("key-1000 in a:", a.data["key-1000"].Value)
+	// Output:
+	// differing buckets: 3 of 64
+	// replicas equal: true
+	// key-7 in b: written to a
+	// key-42 in a: written to b
+	// key-1000 in a: new in b
+}
@@ -0,0 +1,236 @@
+package antientropy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// ProtocolVersion is the version of the protocol sent in the Hello message. Peers with different versions don't sync.
+const ProtocolVersion = 1
+
+const (
+	// maxFrameSize limits the size of a single message accepted from a peer.
+	maxFrameSize = 64 << 20
+	// maxHashSize limits the size of a single hash accepted from a peer.
+	maxHashSize = 512
+)
+
+const (
+	helloType byte = iota + 1
+	hashesType
+	mismatchesType
+)
+
+// Message is a message exchanged by the peers. It is one of *Hello, *Hashes and *Mismatches.
+type Message interface {
+	messageType() byte
+	appendPayload(data []byte) []byte
+	decodePayload(data []byte) error
+}
+
+// Hello is the first message sent by both peers. It carries the protocol version and the number of leaves of the tree.
+type Hello struct {
+	Version int
+	Size    int
+}
+
+// RangeHash is the hash of the subtree of 2^height leaves starting at the leaf with index Lo.
+// The hash is empty when the range is beyond the last leaf of the tree.
+type RangeHash struct {
+	Lo   int
+	Hash []byte
+}
+
+// Hashes is sent by the initiator with the hashes of the ranges of leaves of the given height
+// which are compared on the current level.
+type Hashes struct {
+	Height int
+	Ranges []RangeHash
+}
+
+// Mismatches is sent by the responder in reply to Hashes. Differs tells for every range whether its hashes differ.
+type Mismatches struct {
+	Differs []bool
+}
+
+func (m *Hello) messageType() byte {
+	return helloType
+}
+
+func (m *Hello) appendPayload(data []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(m.Version))
+	return binary.AppendUvarint(data, uint64(m.Size))
+}
+
+func (m *Hello) decodePayload(data []byte) error {
+	version, data, err := readUvarint(data, math.MaxInt)
+	if err != nil {
+		return err
+	}
+	size, data, err := readUvarint(data, math.MaxInt)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		return ErrMalformedMessage
+	}
+	*m = Hello{Version: int(version), Size: int(size)}
+	return nil
+}
+
+func (m *Hashes) messageType() byte {
+	return hashesType
+}
+
+func (m *Hashes) appendPayload(data []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(m.Height))
+	data = binary.AppendUvarint(data, uint64(len(m.Ranges)))
+	for _, r := range m.Ranges {
+		data = binary.AppendUvarint(data, uint64(r.Lo))
+		data = binary.AppendUvarint(data, uint64(len(r.Hash)))
+		data = append(data, r.Hash...)
+	}
+	return data
+}
+
+func (m *Hashes) decodePayload(data []byte) error {
+	height, data, err := readUvarint(data, 62)
+	if err != nil {
+		return err
+	}
+	// every range takes at least two bytes
+	count, data, err := readUvarint(data, uint64(len(data)/2))
+	if err != nil {
+		return err
+	}
+	ranges := make([]RangeHash, count)
+	for i := range ranges {
+		var lo, size uint64
+		lo, data, err = readUvarint(data, math.MaxInt)
+		if err != nil {
+			return err
+		}
+		size, data, err = readUvarint(data, maxHashSize)
+		if err != nil {
+			return err
+		}
+		if uint64(len(data)) < size {
+			return ErrMalformedMessage
+		}
+		ranges[i] = RangeHash{Lo: int(lo), Hash: append([]byte{}, data[:size]...)}
+		data = data[size:]
+	}
+	if len(data) > 0 {
+		return ErrMalformedMessage
+	}
+	*m = Hashes{Height: int(height), Ranges: ranges}
+	return nil
+}
+
+func (m *Mismatches) messageType() byte {
+	return mismatchesType
+}
+
+func (m *Mismatches) appendPayload(data []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(len(m.Differs)))
+	bitmap := make([]byte, (len(m.Differs)+7)/8)
+	for i, differs := range m.Differs {
+		if differs {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	return append(data, bitmap...)
+}
+
+func (m *Mismatches) decodePayload(data []byte) error {
+	count, data, err := readUvarint(data, uint64(len(data))*8)
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) != (count+7)/8 {
+		return ErrMalformedMessage
+	}
+	differs := make([]bool, count)
+	for i := range differs {
+		differs[i] = data[i/8]&(1<<(i%8)) != 0
+	}
+	*m = Mismatches{Differs: differs}
+	return nil
+}
+
+// WriteMessage writes the message to w as a frame made of the message type, the length of the payload and the payload.
+func WriteMessage(w io.Writer, m Message) error {
+	payload := m.appendPayload(nil)
+	if len(payload) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, 0, 1+binary.MaxVarintLen64+len(payload))
+	frame = append(frame, m.messageType())
+	frame = binary.AppendUvarint(frame, uint64(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// ReadMessage reads a message written by WriteMessage from r.
+// It returns an error if the frame is malformed, too large or of an unknown type.
+func ReadMessage(r io.Reader) (Message, error) {
+	br := byteReader{r}
+	msgType, err := br.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var m Message
+	switch msgType {
+	case helloType:
+		m = &Hello{}
+	case hashesType:
+		m = &Hashes{}
+	case mismatchesType:
+		m = &Mismatches{}
+	default:
+		return nil, ErrMalformedMessage
+	}
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if size > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if err := m.decodePayload(payload); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// byteReader reads single bytes without buffering, so no data following the frame is consumed.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func readUvarint(data []byte, limit uint64) (uint64, []byte, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 || v > limit {
+		return 0, nil, ErrMalformedMessage
+	}
+	return v, data[n:], nil
+}
@@ -0,0 +1,261 @@
+// Package antientropy implements a protocol with which two peers holding Merkle trees find the leaves
+// in which their trees differ, exchanging only the hashes of the subtrees which differ.
+//
+// Both peers compare the hashes of aligned ranges of leaves level by level, starting with the range covering
+// all the leaves of the larger tree. Only the halves of the ranges with different hashes are compared
+// on the next level, so finding k differing leaves of n takes O(log n) round trips and O(k log n) hashes.
+// The protocol works over any io.ReadWriter. One peer is the initiator sending the hashes of its ranges
+// and the other one is the responder replying which of them differ, so the peers never write at the same time
+// and the protocol works over synchronous connections like net.Pipe. When it completes, both peers know
+// the indices of the differing leaves and can repair them, e.g. by exchanging the corresponding data.
+// The leaves present in only one of the trees are returned as a range, so the memory used by a peer
+// doesn't depend on the size of the tree of the other peer.
+//
+// The trees have to be built with the same hashing function and options and must not be modified during the sync.
+package antientropy
+
+import (
+	"bytes"
+	"io"
+	"math/bits"
+	"sort"
+
+	"github.com/dogenkigen/merkletree"
+)
+
+// Role determines which messages a peer sends.
+type Role int
+
+const (
+	// Initiator sends the hashes of the compared ranges.
+	Initiator Role = iota
+	// Responder compares the received hashes with its own ones and replies which of them differ.
+	Responder
+)
+
+// Session is the state of one peer during a sync. A session is used for a single sync.
+type Session struct {
+	tree     *merkletree.MerkleTree
+	role     Role
+	size     int
+	peerSize int
+	// height and ranges describe the ranges of leaves compared on the current level
+	height int
+	ranges []int
+	diff   []int
+}
+
+// Result is the outcome of a sync.
+type Result struct {
+	// Differ holds the indices of the leaves present in both trees which differ, in ascending order.
+	Differ []int
+	// Extra is the range of the leaves present in only one of the trees, which all differ.
+	// It is empty if the trees have the same size.
+	Extra Range
+}
+
+// Range is the range of leaf indices from Lo up to, but not including, Hi.
+type Range struct {
+	Lo int
+	Hi int
+}
+
+// Len returns the number of leaves in the range.
+func (r Range) Len() int {
+	return r.Hi - r.Lo
+}
+
+// Indices returns the indices of all the differing leaves in ascending order, including the ones present
+// in only one of the trees. The size of the result depends on the size of the tree of the peer,
+// so it should only be used with trusted peers.
+func (r *Result) Indices() []int {
+	var indices []int
+	indices = append(indices, r.Differ...)
+	for idx := r.Extra.Lo; idx < r.Extra.Hi; idx++ {
+		indices = append(indices, idx)
+	}
+	return indices
+}
+
+// NewSession creates a session of a peer with the given role syncing the tree.
+func NewSession(tree *merkletree.MerkleTree, role Role) *Session {
+	return &Session{tree: tree, role: role}
+}
+
+// Sync runs the protocol with a peer over rw and returns the leaves which differ between the trees.
+func Sync(rw io.ReadWriter, tree *merkletree.MerkleTree, role Role) (*Result, error) {
+	return NewSession(tree, role).Run(rw)
+}
+
+// Run runs the protocol with a peer over rw and returns the leaves which differ between the trees.
+func (s *Session) Run(rw io.ReadWriter) (*Result, error) {
+	if err := s.handshake(rw); err != nil {
+		return nil, err
+	}
+	for len(s.ranges) > 0 {
+		hashes, err := s.hashes()
+		if err != nil {
+			return nil, err
+		}
+		var differs []bool
+		if s.role == Initiator {
+			differs, err = s.sendHashes(rw, hashes)
+		} else {
+			differs, err = s.compareHashes(rw, hashes)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.advance(differs)
+	}
+	sort.Ints(s.diff)
+	minSize, maxSize := s.size, s.peerSize
+	if minSize > maxSize {
+		minSize, maxSize = maxSize, minSize
+	}
+	return &Result{Differ: s.diff, Extra: Range{Lo: minSize, Hi: maxSize}}, nil
+}
+
+// handshake exchanges the sizes of the trees and sets up the range covering all the leaves of the larger one.
+func (s *Session) handshake(rw io.ReadWriter) error {
+	s.size = s.tree.Len()
+	hello := &Hello{Version: ProtocolVersion, Size: s.size}
+	if s.role == Initiator {
+		if err := WriteMessage(rw, hello); err != nil {
+			return err
+		}
+	}
+	m, err := ReadMessage(rw)
+	if err != nil {
+		return err
+	}
+	peerHello, ok := m.(*Hello)
+	if !ok {
+		return ErrUnexpectedMessage
+	}
+	if s.role == Responder {
+		if err := WriteMessage(rw, hello); err != nil {
+			return err
+		}
+	}
+	if peerHello.Version != ProtocolVersion {
+		return ErrVersionMismatch
+	}
+	s.peerSize = peerHello.Size
+	maxSize := s.size
+	if s.peerSize > maxSize {
+		maxSize = s.peerSize
+	}
+	if maxSize > 0 {
+		s.height = bits.Len(uint(maxSize - 1))
+		s.ranges = []int{0}
+	}
+	s.compact()
+	return nil
+}
+
+// hashes returns the hashes of the ranges compared on the current level.
+// The hash of a range beyond the last leaf of the tree is empty.
+func (s *Session) hashes() ([]RangeHash, error) {
+	hashes := make([]RangeHash, len(s.ranges))
+	for i, lo := range s.ranges {
+		hashes[i].Lo = lo
+		if lo < s.size {
+			hash, err := s.tree.SubtreeHash(lo, s.height)
+			if err != nil {
+				return nil, err
+			}
+			hashes[i].Hash = hash
+		}
+	}
+	return hashes, nil
+}
+
+// sendHashes sends the hashes of the initiator and returns the reply of the responder.
+func (s *Session) sendHashes(rw io.ReadWriter, hashes []RangeHash) ([]bool, error) {
+	if err := WriteMessage(rw, &Hashes{Height: s.height, Ranges: hashes}); err != nil {
+		return nil, err
+	}
+	m, err := ReadMessage(rw)
+	if err != nil {
+		return nil, err
+	}
+	mismatches, ok := m.(*Mismatches)
+	if !ok || len(mismatches.Differs) != len(hashes) {
+		return nil, ErrUnexpectedMessage
+	}
+	return mismatches.Differs, nil
+}
+
+// compareHashes compares the hashes received from the initiator with the own ones and replies which of them differ.
+func (s *Session) compareHashes(rw io.ReadWriter, hashes []RangeHash) ([]bool, error) {
+	m, err := ReadMessage(rw)
+	if err != nil {
+		return nil, err
+	}
+	peerHashes, ok := m.(*Hashes)
+	if !ok || peerHashes.Height != s.height || len(peerHashes.Ranges) != len(hashes) {
+		return nil, ErrUnexpectedMessage
+	}
+	differs := make([]bool, len(hashes))
+	for i, r := range peerHashes.Ranges {
+		if r.Lo != hashes[i].Lo {
+			return nil, ErrUnexpectedMessage
+		}
+		differs[i] = s.straddlesEnd(r.Lo) || !bytes.Equal(r.Hash, hashes[i].Hash)
+	}
+	if err := WriteMessage(rw, &Mismatches{Differs: differs}); err != nil {
+		return nil, err
+	}
+	return differs, nil
+}
+
+// straddlesEnd reports whether the range starting at lo on the current level holds both leaves present in both trees
+// and leaves present only in the larger one. The hash of such a range in the smaller tree is calculated
+// as if the tree was extended, which in the DuplicateOdd mode duplicates its last nodes, so it can equal the hash
+// of the range in the larger tree even if the extra leaves differ. Such a range is always considered different.
+func (s *Session) straddlesEnd(lo int) bool {
+	minSize := s.size
+	if s.peerSize < minSize {
+		minSize = s.peerSize
+	}
+	return s.size != s.peerSize && lo < minSize && uint(minSize-lo) < uint(1)<<s.height
+}
+
+// advance moves to the next level, comparing the halves of the ranges which differ.
+// The differing ranges of single leaves are the result.
+func (s *Session) advance(differs []bool) {
+	var next []int
+	for i, lo := range s.ranges {
+		if !differs[i] {
+			continue
+		}
+		if s.height == 0 {
+			s.diff = append(s.diff, lo)
+			continue
+		}
+		next = append(next, lo)
+		if mid := lo + 1<<(s.height-1); mid < s.size || mid < s.peerSize {
+			next = append(next, mid)
+		}
+	}
+	s.ranges = next
+	s.height--
+	s.compact()
+}
+
+// compact removes the ranges which are beyond the last leaf of the smaller tree from the comparison,
+// since all of their leaves differ and are returned as the extra range.
+func (s *Session) compact() {
+	minSize := s.size
+	if s.peerSize < minSize {
+		minSize = s.peerSize
+	}
+	next := s.ranges[:0]
+	for _, lo := range s.ranges {
+		if lo < minSize {
+			next = append(next, lo)
+		}
+	}
+	s.ranges = next
+}
@@ -0,0 +1,276 @@
+package antientropy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/dogenkigen/merkletree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTree(t *testing.T, contents [][]byte, opts ...merkletree.Option) *merkletree.MerkleTree {
+	leaves := make([]*merkletree.Leaf, len(contents))
+	for i, content := range contents {
+		leaves[i] = merkletree.NewLeaf(content)
+	}
+	tree, err := merkletree.NewMerkleTree(leaves, merkletree.SHA256Hasher, opts...)
+	require.NoError(t, err)
+	return tree
+}
+
+// syncOverPipe syncs the trees over net.Pipe and returns the results of both peers.
+func syncOverPipe(t *testing.T, initiator, responder *merkletree.MerkleTree) ([]int, []int) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	type result struct {
+		diff []int
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		res, err := Sync(b, responder, Responder)
+		if err != nil {
+			done <- result{nil, err}
+			return
+		}
+		done <- result{res.Indices(), nil}
+	}()
+	res, err := Sync(a, initiator, Initiator)
+	require.NoError(t, err)
+	r := <-done
+	require.NoError(t, r.err)
+	return res.Indices(), r.diff
+}
+
+func TestSync(t *testing.T) {
+	modes := map[string]merkletree.Mode{
+		"duplicate odd": merkletree.DuplicateOdd,
+		"rfc6962":       merkletree.RFC6962,
+		"sorted pair":   merkletree.SortedPair,
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for name, mode := range modes {
+		for _, sizes := range [][2]int{{1, 1}, {1, 3}, {7, 7}, {8, 5}, {16, 17}, {100, 100}, {100, 37}} {
+			t.Run(fmt.Sprintf("%s %d and %d leaves", name, sizes[0], sizes[1]), func(t *testing.T) {
+				contents := make([][]byte, sizes[0])
+				for i := range contents {
+					contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+				}
+				other := make([][]byte, sizes[1])
+				for i := range other {
+					if i < len(contents) && rnd.Intn(5) > 0 {
+						other[i] = contents[i]
+					} else {
+						other[i] = []byte(fmt.Sprintf("other leaf %d", i))
+					}
+				}
+				a := newTree(t, contents, merkletree.WithMode(mode))
+				b := newTree(t, other, merkletree.WithMode(mode))
+
+				initiatorDiff, responderDiff := syncOverPipe(t, a, b)
+				assert.Equal(t, merkletree.Diff(a, b), initiatorDiff)
+				assert.Equal(t, initiatorDiff, responderDiff)
+			})
+		}
+	}
+}
+
+// countingConn counts the messages written to a connection.
+type countingConn struct {
+	io.ReadWriter
+	writes int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return c.ReadWriter.Write(p)
+}
+
+func TestSync_RoundTrips(t *testing.T) {
+	contents := make([][]byte, 1<<10)
+	for i := range contents {
+		contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	a := newTree(t, contents)
+	contents[123] = []byte("modified")
+	b := newTree(t, contents)
+
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+	done := make(chan error)
+	go func() {
+		_, err := Sync(right, b, Responder)
+		done <- err
+	}()
+	conn := &countingConn{ReadWriter: left}
+	res, err := Sync(conn, a, Initiator)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	assert.Equal(t, &Result{Differ: []int{123}, Extra: Range{Lo: 1 << 10, Hi: 1 << 10}}, res)
+	// a hello and a message for each of the 11 levels
+	assert.Equal(t, 12, conn.writes)
+}
+
+// TestSync_DuplicatedLastLeaf syncs trees which differ only in a leaf equal to the duplicated last leaf
+// of the smaller tree, so the padded hashes of the smaller tree equal the hashes of the larger one.
+func TestSync_DuplicatedLastLeaf(t *testing.T) {
+	for _, contents := range [][]string{{"a", "b", "c"}, {"a", "b", "c", "d", "e"}} {
+		small := make([][]byte, len(contents))
+		for i, content := range contents {
+			small[i] = []byte(content)
+		}
+		large := append(append([][]byte{}, small...), small[len(small)-1])
+		a, b := newTree(t, small), newTree(t, large)
+		expected := merkletree.Diff(a, b)
+		require.Equal(t, []int{len(small)}, expected)
+
+		initiatorDiff, responderDiff := syncOverPipe(t, a, b)
+		assert.Equal(t, expected, initiatorDiff)
+		assert.Equal(t, expected, responderDiff)
+		initiatorDiff, responderDiff = syncOverPipe(t, b, a)
+		assert.Equal(t, expected, initiatorDiff)
+		assert.Equal(t, expected, responderDiff)
+	}
+}
+
+func TestSync_Extra(t *testing.T) {
+	contents := [][]byte{[]byte("one"), []byte("two"), []byte("three"), []byte("four"), []byte("five")}
+	a, b := newTree(t, contents[:2]), newTree(t, contents)
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+	done := make(chan error)
+	go func() {
+		_, err := Sync(right, b, Responder)
+		done <- err
+	}()
+	res, err := Sync(left, a, Initiator)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+	assert.Empty(t, res.Differ)
+	assert.Equal(t, Range{Lo: 2, Hi: 5}, res.Extra)
+	assert.Equal(t, 3, res.Extra.Len())
+	assert.Equal(t, []int{2, 3, 4}, res.Indices())
+}
+
+// TestSync_HugePeer syncs with a peer claiming a huge tree and marking every range as differing.
+// The leaves beyond the local tree are returned as a range instead of being listed.
+func TestSync_HugePeer(t *testing.T) {
+	tree := newTree(t, [][]byte{[]byte("one"), []byte("two"), []byte("three")})
+	const size = 1 << 40
+	messages := []Message{&Hello{Version: ProtocolVersion, Size: size}}
+	// a single range is left on each level above the local leaves
+	for height := 40; height > 1; height-- {
+		messages = append(messages, &Mismatches{Differs: []bool{true}})
+	}
+	messages = append(messages, &Mismatches{Differs: []bool{true, true}}, &Mismatches{Differs: []bool{true, true, true}})
+	res, err := Sync(newScripted(t, messages...), tree, Initiator)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, res.Differ)
+	assert.Equal(t, Range{Lo: 3, Hi: size}, res.Extra)
+}
+
+func TestSync_Equal(t *testing.T) {
+	contents := [][]byte{[]byte("one"), []byte("two"), []byte("three")}
+	initiatorDiff, responderDiff := syncOverPipe(t, newTree(t, contents), newTree(t, contents))
+	assert.Empty(t, initiatorDiff)
+	assert.Empty(t, responderDiff)
+}
+
+// scripted is a peer replying with the given messages regardless of what it reads.
+type scripted struct {
+	bytes.Buffer
+	written bytes.Buffer
+}
+
+func (s *scripted) Write(p []byte) (int, error) {
+	return s.written.Write(p)
+}
+
+func newScripted(t *testing.T, messages ...Message) *scripted {
+	s := &scripted{}
+	for _, m := range messages {
+		require.NoError(t, WriteMessage(&s.Buffer, m))
+	}
+	return s
+}
+
+func TestSync_ProtocolErrors(t *testing.T) {
+	tree := newTree(t, [][]byte{[]byte("one"), []byte("two"), []byte("three")})
+	testCases := []struct {
+		name     string
+		role     Role
+		messages []Message
+		err      error
+	}{
+		{"version mismatch", Initiator, []Message{&Hello{Version: 2, Size: 3}}, ErrVersionMismatch},
+		{"missing hello", Responder, []Message{&Mismatches{}}, ErrUnexpectedMessage},
+		{"wrong number of mismatches", Initiator, []Message{
+			&Hello{Version: ProtocolVersion, Size: 3},
+			&Mismatches{Differs: []bool{true, true}},
+		}, ErrUnexpectedMessage},
+		{"wrong height", Responder, []Message{
+			&Hello{Version: ProtocolVersion, Size: 3},
+			&Hashes{Height: 1, Ranges: []RangeHash{{Lo: 0}}},
+		}, ErrUnexpectedMessage},
+		{"wrong range", Responder, []Message{
+			&Hello{Version: ProtocolVersion, Size: 3},
+			&Hashes{Height: 2, Ranges: []RangeHash{{Lo: 4}}},
+		}, ErrUnexpectedMessage},
+		{"closed connection", Initiator, []Message{&Hello{Version: ProtocolVersion, Size: 3}}, io.EOF},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Sync(newScripted(t, tc.messages...), tree, tc.role)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestMessage_RoundTrip(t *testing.T) {
+	messages := []Message{
+		&Hello{Version: ProtocolVersion, Size: 12345},
+		&Hashes{Height: 3, Ranges: []RangeHash{{Lo: 0, Hash: []byte{1, 2, 3}}, {Lo: 8, Hash: []byte{}}}},
+		&Mismatches{Differs: []bool{true, false, false, true, true, false, false, false, true}},
+	}
+	var buf bytes.Buffer
+	for _, m := range messages {
+		require.NoError(t, WriteMessage(&buf, m))
+	}
+	for _, m := range messages {
+		decoded, err := ReadMessage(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, m, decoded)
+	}
+	_, err := ReadMessage(&buf)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadMessage_Errors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"unknown type", []byte{9, 0}, ErrMalformedMessage},
+		{"missing length", []byte{helloType}, io.ErrUnexpectedEOF},
+		{"truncated payload", []byte{helloType, 2, 1}, io.ErrUnexpectedEOF},
+		{"too large", []byte{hashesType, 0xff, 0xff, 0xff, 0xff, 0x0f}, ErrFrameTooLarge},
+		{"trailing data", []byte{helloType, 3, 1, 1, 1}, ErrMalformedMessage},
+		{"truncated hash", []byte{hashesType, 5, 0, 1, 0, 4, 1}, ErrMalformedMessage},
+		{"wrong bitmap", []byte{mismatchesType, 3, 20, 1, 1}, ErrMalformedMessage},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadMessage(bytes.NewReader(tc.frame))
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package merkletree
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Default sizes of content-defined chunks in bytes.
+const (
+	DefaultMinChunkSize = 2 << 10
+	DefaultAvgChunkSize = 8 << 10
+	DefaultMaxChunkSize = 64 << 10
+)
+
+// Chunker splits the data read from an io.Reader into content-defined chunks using the FastCDC algorithm
+// with a Gear rolling hash. A chunk boundary only depends on the bytes preceding it, so inserting or removing
+// data changes the chunks around the modification while the rest of the chunks stay the same.
+// Trees built from the chunks of similar files therefore share most of their leaf hashes.
+type Chunker struct {
+	r       io.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	// maskS is used before the chunk reaches the average size and makes a boundary less likely,
+	// maskL is used afterwards and makes it more likely, which narrows the distribution of chunk sizes.
+	maskS uint64
+	maskL uint64
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+// NewChunker creates a chunker reading from r and producing chunks of at least minSize and at most maxSize bytes,
+// except for the last one which may be shorter. The average size is rounded down to a power of two.
+// It returns ErrInvalidChunkSize unless 0 < minSize <= avgSize <= maxSize.
+func NewChunker(r io.Reader, minSize, avgSize, maxSize int) (*Chunker, error) {
+	if minSize <= 0 || minSize > avgSize || avgSize > maxSize {
+		return nil, ErrInvalidChunkSize
+	}
+	avgBits := bits.Len(uint(avgSize)) - 1
+	return &Chunker{
+		r:       r,
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		maskS:   topBitsMask(avgBits + 1),
+		maskL:   topBitsMask(avgBits - 1),
+		buf:     make([]byte, maxSize),
+	}, nil
+}
+
+// topBitsMask returns a mask of the n most significant bits. They depend on the last 64 bytes
+// seen by the Gear hash, while the least significant ones only depend on the last few bytes.
+func topBitsMask(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk, or io.EOF when all the data has been read.
+// The chunk is only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill reads data until the buffer holds a chunk of the maximum size or the reader is exhausted.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= c.maxSize {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	n, err := io.ReadFull(c.r, c.buf[c.end:])
+	c.end += n
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+// cut returns the length of the chunk at the beginning of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.minSize {
+		return n
+	}
+	if n > c.maxSize {
+		n = c.maxSize
+	}
+	normal := c.avgSize
+	if normal > n {
+		normal = n
+	}
+	var fp uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// gearTable holds the random values of the bytes added to the Gear hash. It is generated with splitmix64
+// from a fixed seed, so the chunk boundaries never change for the same data and chunk sizes.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6d65726b6c657472) // "merkletr"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
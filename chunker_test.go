@@ -0,0 +1,134 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkAll(t *testing.T, r io.Reader, minSize, avgSize, maxSize int) [][]byte {
+	c, err := NewChunker(r, minSize, avgSize, maxSize)
+	require.NoError(t, err)
+	var chunks [][]byte
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			return chunks
+		}
+		require.NoError(t, err)
+		chunks = append(chunks, append([]byte{}, chunk...))
+	}
+}
+
+func TestChunker_Next(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	chunks := chunkAll(t, bytes.NewReader(data), 1<<10, 4<<10, 16<<10)
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), 16<<10)
+		if i < len(chunks)-1 {
+			assert.GreaterOrEqual(t, len(chunk), 1<<10)
+		}
+	}
+	avg := len(data) / len(chunks)
+	assert.Greater(t, avg, 2<<10)
+	assert.Less(t, avg, 8<<10)
+
+	// the boundaries don't depend on how the data is read
+	assert.Equal(t, chunks, chunkAll(t, iotest.OneByteReader(bytes.NewReader(data)), 1<<10, 4<<10, 16<<10))
+}
+
+func TestChunker_Next_ShortAndEmpty(t *testing.T) {
+	assert.Equal(t, [][]byte{[]byte("short")}, chunkAll(t, bytes.NewReader([]byte("short")), 64, 128, 256))
+	assert.Empty(t, chunkAll(t, bytes.NewReader(nil), 64, 128, 256))
+
+	// data without any boundary is split at the maximum size
+	chunks := chunkAll(t, bytes.NewReader(make([]byte, 1000)), 64, 128, 256)
+	assert.Equal(t, []int{256, 256, 256, 232}, chunkLengths(chunks))
+}
+
+func TestChunker_Next_SharedChunksAfterInsertion(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+	modified := append(append(append([]byte{}, data[:300_000]...), []byte("inserted bytes")...), data[300_000:]...)
+
+	original := chunkAll(t, bytes.NewReader(data), DefaultMinChunkSize, DefaultAvgChunkSize, DefaultMaxChunkSize)
+	changed := chunkAll(t, bytes.NewReader(modified), DefaultMinChunkSize, DefaultAvgChunkSize, DefaultMaxChunkSize)
+	known := make(map[string]bool)
+	for _, chunk := range original {
+		known[string(SHA256Hasher(chunk))] = true
+	}
+	shared := 0
+	for _, chunk := range changed {
+		if known[string(SHA256Hasher(chunk))] {
+			shared++
+		}
+	}
+	assert.GreaterOrEqual(t, shared, len(changed)-2)
+}
+
+func TestChunker_Next_ReadError(t *testing.T) {
+	readErr := errors.New("read failed")
+	c, err := NewChunker(io.MultiReader(bytes.NewReader([]byte("data")), iotest.ErrReader(readErr)), 64, 128, 256)
+	require.NoError(t, err)
+	_, err = c.Next()
+	assert.ErrorIs(t, err, readErr)
+}
+
+func TestNewChunker_InvalidSizes(t *testing.T) {
+	for _, sizes := range [][3]int{{0, 8, 16}, {16, 8, 32}, {4, 16, 8}, {-1, 8, 16}} {
+		_, err := NewChunker(bytes.NewReader(nil), sizes[0], sizes[1], sizes[2])
+		assert.ErrorIs(t, err, ErrInvalidChunkSize)
+	}
+}
+
+func TestNewMerkleTreeFromChunker(t *testing.T) {
+	data := make([]byte, 200_000)
+	rand.New(rand.NewSource(3)).Read(data)
+	chunks := chunkAll(t, bytes.NewReader(data), 1<<10, 4<<10, 16<<10)
+	expected, err := NewMerkleTree(newLeaves(chunks), SHA256Hasher, WithMode(RFC6962))
+	require.NoError(t, err)
+
+	c, err := NewChunker(bytes.NewReader(data), 1<<10, 4<<10, 16<<10)
+	require.NoError(t, err)
+	tree, err := NewMerkleTreeFromChunker(c, SHA256Hasher, WithMode(RFC6962))
+	require.NoError(t, err)
+	assert.Equal(t, expected.Hash(), tree.Hash())
+
+	c, err = NewChunker(bytes.NewReader(nil), 1<<10, 4<<10, 16<<10)
+	require.NoError(t, err)
+	_, err = NewMerkleTreeFromChunker(c, SHA256Hasher)
+	assert.ErrorIs(t, err, ErrEmptyTree)
+}
+
+func chunkLengths(chunks [][]byte) []int {
+	lengths := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		lengths[i] = len(chunk)
+	}
+	return lengths
+}
+
+func BenchmarkChunker_Next(b *testing.B) {
+	data := make([]byte, 64<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := NewChunker(bytes.NewReader(data), DefaultMinChunkSize, DefaultAvgChunkSize, DefaultMaxChunkSize)
+		require.NoError(b, err)
+		for {
+			if _, err := c.Next(); err != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Command merkletree builds Merkle trees from files or lines of the standard input,
+// prints their root hashes and creates and verifies proofs of their leaves.
+//
+// Usage:
+//
+//	merkletree root   [--hash name] [--mode name] [file ...]
+//	merkletree proof  [--hash name] [--mode name] --index n [file ...]
+//	merkletree verify [--hash name] [--mode name] --root hex --leaves n [--proof file] [--leaf file]
+//	merkletree print  [--hash name] [--mode name] [file ...]
+//
+// Every file is a leaf of the tree. Without files every line of the standard input is a leaf.
+// Proofs are written and read as JSON. The verify command reads the proof from the standard input
+// unless --proof is given and exits with a non-zero status if the proof is not valid.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dogenkigen/merkletree"
+)
+
+var hashers = map[string]merkletree.Hasher{
+	"sha256":     merkletree.SHA256Hasher,
+	"sha512":     merkletree.SHA512Hasher,
+	"blake2b256": merkletree.Blake2b256Hasher,
+	"blake2b512": merkletree.Blake2b512Hasher,
+	"keccak256":  merkletree.Keccak256Hasher,
+	"sha3-256":   merkletree.SHA3_256Hasher,
+	"sha3-512":   merkletree.SHA3_512Hasher,
+	"shake128":   merkletree.NewSHAKE128Hasher(32),
+	"shake256":   merkletree.NewSHAKE256Hasher(64),
+	"md5":        merkletree.MD5Hasher,
+}
+
+var modes = map[string]merkletree.Mode{
+	"duplicate-odd": merkletree.DuplicateOdd,
+	"rfc6962":       merkletree.RFC6962,
+	"sorted-pair":   merkletree.SortedPair,
+}
+
+// maxLineSize is the size of the longest line of the standard input read as a leaf.
+const maxLineSize = 16 << 20
+
+const usage = `usage: merkletree <command> [flags] [file ...]
+
+commands:
+  root    print the root hash of the tree
+  proof   print the proof of the leaf at --index as JSON
+  verify  verify a proof against --root of a tree with --leaves leaves
+  print   print the tree
+
+Every file is a leaf of the tree. Without files every line of the standard input is a leaf.
+Run merkletree <command> -h for the flags of a command.
+`
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, "merkletree:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errUsage
+	}
+	cmd := &command{name: args[0], stdin: stdin, stdout: stdout}
+	cmd.flags = flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmd.flags.SetOutput(stderr)
+	cmd.flags.StringVar(&cmd.hash, "hash", "sha256", "hashing function: "+names(hashers))
+	cmd.flags.StringVar(&cmd.mode, "mode", "duplicate-odd", "tree mode: "+names(modes))
+	switch cmd.name {
+	case "root":
+		return cmd.run(args[1:], cmd.root)
+	case "proof":
+		cmd.flags.IntVar(&cmd.index, "index", -1, "index of the proven leaf")
+		return cmd.run(args[1:], cmd.proof)
+	case "verify":
+		cmd.flags.StringVar(&cmd.rootHash, "root", "", "hex encoded root hash of the tree")
+		cmd.flags.IntVar(&cmd.leafCount, "leaves", 0, "number of leaves of the tree")
+		cmd.flags.StringVar(&cmd.proofFile, "proof", "", "file holding the JSON proof, the standard input by default")
+		cmd.flags.StringVar(&cmd.leafFile, "leaf", "", "file holding the content of the proven leaf, if it has to be checked")
+		return cmd.run(args[1:], cmd.verify)
+	case "print":
+		return cmd.run(args[1:], cmd.print)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	}
+	fmt.Fprintf(stderr, "unknown command %q\n\n%s", cmd.name, usage)
+	return errUsage
+}
+
+type command struct {
+	name   string
+	flags  *flag.FlagSet
+	stdin  io.Reader
+	stdout io.Writer
+	hasher merkletree.Hasher
+	opts   []merkletree.Option
+
+	hash      string
+	mode      string
+	index     int
+	rootHash  string
+	leafCount int
+	proofFile string
+	leafFile  string
+}
+
+func (c *command) run(args []string, f func() error) error {
+	if err := c.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+	var ok bool
+	if c.hasher, ok = hashers[c.hash]; !ok {
+		return fmt.Errorf("unknown hashing function %q, available: %s", c.hash, names(hashers))
+	}
+	mode, ok := modes[c.mode]
+	if !ok {
+		return fmt.Errorf("unknown mode %q, available: %s", c.mode, names(modes))
+	}
+	c.opts = []merkletree.Option{merkletree.WithMode(mode)}
+	return f()
+}
+
+func (c *command) root() error {
+	tree, err := c.buildTree()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.stdout, hex.EncodeToString(tree.Hash()))
+	return err
+}
+
+func (c *command) proof() error {
+	if c.index < 0 {
+		return errors.New("--index is required")
+	}
+	tree, err := c.buildTree()
+	if err != nil {
+		return err
+	}
+	proof, err := tree.GenerateProof(c.index)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(proof)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.stdout, string(data))
+	return err
+}
+
+func (c *command) verify() error {
+	if c.rootHash == "" || c.leafCount <= 0 {
+		return errors.New("--root and --leaves are required")
+	}
+	if c.flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(c.flags.Args(), " "))
+	}
+	root, err := hex.DecodeString(c.rootHash)
+	if err != nil {
+		return fmt.Errorf("invalid root hash: %w", err)
+	}
+	data, err := c.readInput(c.proofFile)
+	if err != nil {
+		return err
+	}
+	proof := &merkletree.Proof{}
+	if err := json.Unmarshal(data, proof); err != nil {
+		return fmt.Errorf("invalid proof: %w", err)
+	}
+	if c.leafFile != "" {
+		content, err := os.ReadFile(c.leafFile)
+		if err != nil {
+			return err
+		}
+		if err := c.checkLeaf(proof, content); err != nil {
+			return err
+		}
+	}
+	if err := merkletree.VerifyProof(proof, root, c.hasher, c.leafCount, c.opts...); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.stdout, "OK")
+	return err
+}
+
+// checkLeaf compares the hash of the content with the leaf hash of the proof.
+func (c *command) checkLeaf(proof *merkletree.Proof, content []byte) error {
+	hasher := merkletree.NewStreamHasherFromHasher(c.hasher)
+	hash, err := merkletree.HashLeafFromReader(bytes.NewReader(content), hasher, c.opts...)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, proof.LeafHash()) {
+		return &merkletree.LeafHashMismatchError{Index: proof.LeafIndex(), Provided: proof.LeafHash(), Expected: hash}
+	}
+	return nil
+}
+
+func (c *command) print() error {
+	tree, err := c.buildTree()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.stdout, tree.String())
+	return err
+}
+
+// buildTree builds the tree from the files given as arguments or from the lines of the standard input.
+func (c *command) buildTree() (*merkletree.MerkleTree, error) {
+	var leaves []*merkletree.Leaf
+	if c.flags.NArg() > 0 {
+		for _, name := range c.flags.Args() {
+			content, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			leaves = append(leaves, merkletree.NewLeaf(content))
+		}
+	} else {
+		scanner := bufio.NewScanner(c.stdin)
+		scanner.Buffer(nil, maxLineSize)
+		for scanner.Scan() {
+			leaves = append(leaves, merkletree.NewLeaf([]byte(scanner.Text())))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return merkletree.NewMerkleTree(leaves, c.hasher, c.opts...)
+}
+
+// readInput reads the named file, or the standard input if the name is empty.
+func (c *command) readInput(name string) ([]byte, error) {
+	if name == "" {
+		return io.ReadAll(c.stdin)
+	}
+	return os.ReadFile(name)
+}
+
+func names[T any](m map[string]T) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dogenkigen/merkletree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runCommand(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestRun_Root(t *testing.T) {
+	tree, err := merkletree.NewMerkleTree([]*merkletree.Leaf{
+		merkletree.NewLeaf([]byte("one")),
+		merkletree.NewLeaf([]byte("two")),
+		merkletree.NewLeaf([]byte("three")),
+	}, merkletree.Keccak256Hasher, merkletree.WithMode(merkletree.RFC6962))
+	require.NoError(t, err)
+	expected := hex.EncodeToString(tree.Hash()) + "\n"
+
+	out, err := runCommand(t, "one\ntwo\nthree\n", "root", "--hash", "keccak256", "--mode", "rfc6962")
+	require.NoError(t, err)
+	assert.Equal(t, expected, out)
+
+	dir := t.TempDir()
+	var files []string
+	for _, content := range []string{"one", "two", "three"} {
+		name := filepath.Join(dir, content)
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+		files = append(files, name)
+	}
+	out, err = runCommand(t, "", append([]string{"root", "--hash=keccak256", "--mode=rfc6962"}, files...)...)
+	require.NoError(t, err)
+	assert.Equal(t, expected, out)
+}
+
+func TestRun_ProofAndVerify(t *testing.T) {
+	const input = "a\nb\nc\nd\ne\n"
+	root, err := runCommand(t, input, "root", "--hash", "sha3-256")
+	require.NoError(t, err)
+	proof, err := runCommand(t, input, "proof", "--hash", "sha3-256", "--index", "4")
+	require.NoError(t, err)
+	proofFile := filepath.Join(t.TempDir(), "proof.json")
+	require.NoError(t, os.WriteFile(proofFile, []byte(proof), 0o644))
+	leafFile := filepath.Join(t.TempDir(), "leaf")
+	require.NoError(t, os.WriteFile(leafFile, []byte("e"), 0o644))
+
+	verify := []string{"verify", "--hash", "sha3-256", "--root", strings.TrimSpace(root), "--leaves", "5"}
+	out, err := runCommand(t, proof, verify...)
+	require.NoError(t, err)
+	assert.Equal(t, "OK\n", out)
+	_, err = runCommand(t, "", append(verify, "--proof", proofFile, "--leaf", leafFile)...)
+	assert.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(leafFile, []byte("x"), 0o644))
+	_, err = runCommand(t, "", append(verify, "--proof", proofFile, "--leaf", leafFile)...)
+	assert.ErrorIs(t, err, merkletree.ErrLeafHashMismatch)
+	_, err = runCommand(t, proof, "verify", "--hash", "sha256", "--root", strings.TrimSpace(root), "--leaves", "5")
+	assert.Error(t, err)
+	_, err = runCommand(t, proof, "verify", "--hash", "sha3-256", "--root", strings.TrimSpace(root), "--leaves", "16")
+	assert.Error(t, err)
+}
+
+func TestRun_Print(t *testing.T) {
+	tree, err := merkletree.NewMerkleTree([]*merkletree.Leaf{
+		merkletree.NewLeaf([]byte("one")),
+		merkletree.NewLeaf([]byte("two")),
+	}, merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	out, err := runCommand(t, "one\ntwo", "print")
+	require.NoError(t, err)
+	assert.Equal(t, tree.String()+"\n", out)
+}
+
+func TestRun_Errors(t *testing.T) {
+	testCases := []struct {
+		name  string
+		stdin string
+		args  []string
+	}{
+		{"no command", "", nil},
+		{"unknown command", "", []string{"sign"}},
+		{"unknown flag", "a", []string{"root", "--size", "1"}},
+		{"unknown hasher", "a", []string{"root", "--hash", "crc32"}},
+		{"unknown mode", "a", []string{"root", "--mode", "sorted"}},
+		{"empty input", "", []string{"root"}},
+		{"missing index", "a", []string{"proof"}},
+		{"index out of range", "a", []string{"proof", "--index", "1"}},
+		{"missing root", "{}", []string{"verify", "--leaves", "1"}},
+		{"invalid root", "{}", []string{"verify", "--root", "xyz", "--leaves", "1"}},
+		{"invalid proof", "{", []string{"verify", "--root", "00", "--leaves", "1"}},
+		{"missing file", "", []string{"root", filepath.Join(t.TempDir(), "missing")}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := runCommand(t, tc.stdin, tc.args...)
+			assert.Error(t, err)
+		})
+	}
+}
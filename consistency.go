@@ -0,0 +1,162 @@
+package merkletree
+
+import (
+	"bytes"
+	"math/bits"
+)
+
+// GenerateConsistencyProof creates a proof that the tree made of the first newSize leaves is an extension
+// of the tree made of the first oldSize leaves, i.e. that no leaf of the older tree was changed.
+// The proof follows RFC 9162 section 2.1.4 and the tree itself may contain more than newSize leaves.
+// It returns an error if the sizes are out of bounds.
+func (mt *MerkleTree) GenerateConsistencyProof(oldSize, newSize int) ([][]byte, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	if oldSize < 1 || oldSize > newSize || newSize > len(mt.leaves) {
+		return nil, ErrInvalidTreeSize
+	}
+	if oldSize == newSize {
+		return [][]byte{}, nil
+	}
+	mode := mt.opts.mode
+	height := mode.treeHeight(newSize)
+	if !validConsistencyHeight(height) {
+		return nil, ErrInvalidTreeSize
+	}
+	return mt.collectConsistencyHashes(0, height, mode.treeHeight(oldSize), oldSize, newSize, nil), nil
+}
+
+// validConsistencyHeight reports whether the ranges of leaves of a tree of the given height can be calculated
+// without overflowing an int. A larger tree can't be held in memory, so its size can only be forged.
+func validConsistencyHeight(height int) bool {
+	return height < bits.UintSize-2
+}
+
+// collectConsistencyHashes walks the subtree of the given height starting at the leaf with index lo
+// and collects the hashes needed to recalculate both the old and the new root.
+// The hashes of the deeper levels come first.
+func (mt *MerkleTree) collectConsistencyHashes(lo, height, oldHeight, oldSize, newSize int, proof [][]byte) [][]byte {
+	if lo+1<<height <= oldSize {
+		if lo == 0 && height == oldHeight {
+			// the subtree is the old tree itself, its root is already known to the verifier
+			return proof
+		}
+		return append(proof, mt.subtree(lo, height).Hash())
+	}
+	mid := lo + 1<<(height-1)
+	if oldSize <= mid {
+		proof = mt.collectConsistencyHashes(lo, height-1, oldHeight, oldSize, newSize, proof)
+		if mid < newSize {
+			proof = append(proof, mt.subtreeHash(mid, height-1, newSize))
+		}
+		return proof
+	}
+	proof = mt.collectConsistencyHashes(mid, height-1, oldHeight, oldSize, newSize, proof)
+	return append(proof, mt.subtree(lo, height-1).Hash())
+}
+
+// subtreeHash returns the hash of the subtree of the given height starting at the leaf with index lo
+// in the tree made of the first size leaves.
+func (mt *MerkleTree) subtreeHash(lo, height, size int) []byte {
+	if lo+1<<height <= size {
+		return mt.subtree(lo, height).Hash()
+	}
+	mid := lo + 1<<(height-1)
+	left := mt.subtreeHash(lo, height-1, size)
+	nodeHasher := mt.opts.mode.nodeHasher(mt.hasher)
+	if mid >= size {
+		return mt.opts.mode.unpairedHash(left, nodeHasher)
+	}
+	return nodeHasher(left, mt.subtreeHash(mid, height-1, size))
+}
+
+// VerifyConsistencyProof checks that the tree with newSize leaves and the newRoot hash is an extension
+// of the tree with oldSize leaves and the oldRoot hash, using a proof created by GenerateConsistencyProof.
+// Only the root hashes and the sizes of the trees are needed. The options have to match the ones the tree was built with.
+func VerifyConsistencyProof(oldRoot, newRoot []byte, oldSize, newSize int, proof [][]byte, hasher Hasher, opts ...Option) error {
+	if oldSize < 1 || oldSize > newSize {
+		return ErrInvalidTreeSize
+	}
+	if oldSize == newSize {
+		if len(proof) > 0 || !bytes.Equal(oldRoot, newRoot) {
+			return ErrWrongConsistencyProof
+		}
+		return nil
+	}
+	o := newOptions(opts)
+	mode := o.mode
+	height := mode.treeHeight(newSize)
+	if !validConsistencyHeight(height) {
+		return ErrInvalidTreeSize
+	}
+	v := &consistencyVerifier{
+		mode:       mode,
+		nodeHasher: mode.nodeHasher(o.sumHasher(hasher)),
+		oldRoot:    oldRoot,
+		oldHeight:  mode.treeHeight(oldSize),
+		oldSize:    oldSize,
+		newSize:    newSize,
+		proof:      proof,
+	}
+	oldHash, newHash, ok := v.verify(0, height)
+	if !ok || len(v.proof) > 0 || !bytes.Equal(oldHash, oldRoot) || !bytes.Equal(newHash, newRoot) {
+		return ErrWrongConsistencyProof
+	}
+	return nil
+}
+
+type consistencyVerifier struct {
+	mode       Mode
+	nodeHasher pairHasher
+	oldRoot    []byte
+	oldHeight  int
+	oldSize    int
+	newSize    int
+	proof      [][]byte
+}
+
+// verify mirrors collectConsistencyHashes and returns the hashes of the subtree of the given height
+// starting at the leaf with index lo in both the old and the new tree.
+func (v *consistencyVerifier) verify(lo, height int) ([]byte, []byte, bool) {
+	if lo+1<<height <= v.oldSize {
+		if lo == 0 && height == v.oldHeight {
+			return v.oldRoot, v.oldRoot, true
+		}
+		hash, ok := v.next()
+		return hash, hash, ok
+	}
+	mid := lo + 1<<(height-1)
+	if v.oldSize <= mid {
+		oldHash, newHash, ok := v.verify(lo, height-1)
+		if !ok {
+			return nil, nil, false
+		}
+		if height <= v.oldHeight {
+			oldHash = v.mode.unpairedHash(oldHash, v.nodeHasher)
+		}
+		if mid >= v.newSize {
+			return oldHash, v.mode.unpairedHash(newHash, v.nodeHasher), true
+		}
+		right, ok := v.next()
+		return oldHash, v.hashPair(newHash, right), ok
+	}
+	oldHash, newHash, ok := v.verify(mid, height-1)
+	if !ok {
+		return nil, nil, false
+	}
+	left, ok := v.next()
+	return v.hashPair(left, oldHash), v.hashPair(left, newHash), ok
+}
+
+func (v *consistencyVerifier) next() ([]byte, bool) {
+	if len(v.proof) == 0 {
+		return nil, false
+	}
+	hash := v.proof[0]
+	v.proof = v.proof[1:]
+	return hash, true
+}
+
+func (v *consistencyVerifier) hashPair(left, right []byte) []byte {
+	return v.nodeHasher(left, right)
+}
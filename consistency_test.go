@@ -0,0 +1,159 @@
+package merkletree
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/bits"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleTree_GenerateConsistencyProof_RFC6962(t *testing.T) {
+	testCases := []struct {
+		oldSize int
+		newSize int
+		proof   []string
+	}{
+		{1, 1, []string{}},
+		{1, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{6, 8, []string{
+			"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 5, []string{
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	tree := newRFC6962Tree(t, 8)
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d to %d", tc.oldSize, tc.newSize), func(t *testing.T) {
+			proof, err := tree.GenerateConsistencyProof(tc.oldSize, tc.newSize)
+			require.NoError(t, err)
+			hashes := make([]string, 0, len(proof))
+			for _, h := range proof {
+				hashes = append(hashes, hex.EncodeToString(h))
+			}
+			assert.Equal(t, tc.proof, hashes)
+
+			oldRoot := newRFC6962Tree(t, tc.oldSize).Hash()
+			newRoot := newRFC6962Tree(t, tc.newSize).Hash()
+			assert.NoError(t, VerifyConsistencyProof(oldRoot, newRoot, tc.oldSize, tc.newSize, proof, SHA256Hasher, WithMode(RFC6962)))
+		})
+	}
+}
+
+func TestMerkleTree_GenerateConsistencyProof_Iterations(t *testing.T) {
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			const size = 40
+			roots := make([][]byte, 0, size)
+			tree, err := NewMerkleTree([]*Leaf{NewLeaf([]byte("0"))}, SHA256Hasher, WithMode(mode))
+			require.NoError(t, err)
+			roots = append(roots, tree.Hash())
+			for i := 1; i < size; i++ {
+				tree.Append(NewLeaf([]byte(fmt.Sprintf("%d", i))))
+				roots = append(roots, tree.Hash())
+			}
+			for oldSize := 1; oldSize <= size; oldSize++ {
+				for newSize := oldSize; newSize <= size; newSize++ {
+					proof, err := tree.GenerateConsistencyProof(oldSize, newSize)
+					require.NoError(t, err)
+					msg := fmt.Sprintf("from %d to %d leaves", oldSize, newSize)
+					oldRoot, newRoot := roots[oldSize-1], roots[newSize-1]
+					assert.NoError(t, VerifyConsistencyProof(oldRoot, newRoot, oldSize, newSize, proof, SHA256Hasher, WithMode(mode)), msg)
+					if oldSize == newSize {
+						continue
+					}
+					assert.Error(t, VerifyConsistencyProof(newRoot, newRoot, oldSize, newSize, proof, SHA256Hasher, WithMode(mode)), msg)
+					assert.Error(t, VerifyConsistencyProof(oldRoot, oldRoot, oldSize, newSize, proof, SHA256Hasher, WithMode(mode)), msg)
+					if len(proof) > 0 {
+						assert.Error(t, VerifyConsistencyProof(oldRoot, newRoot, oldSize, newSize, proof[1:], SHA256Hasher, WithMode(mode)), msg)
+					}
+					assert.Error(t, VerifyConsistencyProof(oldRoot, newRoot, oldSize, newSize, append(proof, oldRoot), SHA256Hasher, WithMode(mode)), msg)
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_GenerateConsistencyProof_Errors(t *testing.T) {
+	tree := newRFC6962Tree(t, 8)
+	testCases := []struct {
+		name    string
+		oldSize int
+		newSize int
+	}{
+		{"empty old tree", 0, 8},
+		{"old tree larger than new tree", 5, 4},
+		{"new tree larger than tree", 5, 9},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tree.GenerateConsistencyProof(tc.oldSize, tc.newSize)
+			assert.ErrorIs(t, err, ErrInvalidTreeSize)
+		})
+	}
+}
+
+func TestVerifyConsistencyProof_Errors(t *testing.T) {
+	oldTree := newRFC6962Tree(t, 3)
+	newTree := newRFC6962Tree(t, 7)
+	proof, err := newTree.GenerateConsistencyProof(3, 7)
+	require.NoError(t, err)
+	tampered := newRFC6962Tree(t, 7)
+	tampered.leaves[1] = NewLeaf([]byte("tampered"))
+	tampered, err = NewMerkleTree(tampered.leaves, SHA256Hasher, WithMode(RFC6962))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		oldRoot []byte
+		newRoot []byte
+		oldSize int
+		newSize int
+		proof   [][]byte
+		err     error
+	}{
+		{"valid proof", oldTree.Hash(), newTree.Hash(), 3, 7, proof, nil},
+		{"empty old tree", oldTree.Hash(), newTree.Hash(), 0, 7, proof, ErrInvalidTreeSize},
+		{"old tree larger than new tree", oldTree.Hash(), newTree.Hash(), 8, 7, proof, ErrInvalidTreeSize},
+		{"wrong old size", oldTree.Hash(), newTree.Hash(), 2, 7, proof, ErrWrongConsistencyProof},
+		{"rewritten history", oldTree.Hash(), tampered.Hash(), 3, 7, proof, ErrWrongConsistencyProof},
+		{"same size with different roots", oldTree.Hash(), newTree.Hash(), 7, 7, [][]byte{}, ErrWrongConsistencyProof},
+		{"same size with proof", newTree.Hash(), newTree.Hash(), 7, 7, proof, ErrWrongConsistencyProof},
+		{"same size", newTree.Hash(), newTree.Hash(), 7, 7, [][]byte{}, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyConsistencyProof(tc.oldRoot, tc.newRoot, tc.oldSize, tc.newSize, tc.proof, SHA256Hasher, WithMode(RFC6962))
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestVerifyConsistencyProof_OverflowingSize checks that sizes of trees too large for the ranges of their leaves
+// to fit in an int are rejected instead of letting a forged proof pass.
+func TestVerifyConsistencyProof_OverflowingSize(t *testing.T) {
+	for name, mode := range testModes {
+		root := newRFC6962Tree(t, 1).Hash()
+		for _, newSize := range []int{math.MaxInt, 1<<(bits.UintSize-2) + 1} {
+			t.Run(fmt.Sprintf("%s %d leaves", name, newSize), func(t *testing.T) {
+				err := VerifyConsistencyProof(root, root, 1, newSize, [][]byte{root}, SHA256Hasher, WithMode(mode))
+				assert.ErrorIs(t, err, ErrInvalidTreeSize)
+			})
+		}
+	}
+}
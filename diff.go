@@ -0,0 +1,70 @@
+package merkletree
+
+import (
+	"bytes"
+	"unsafe"
+)
+
+// Diff returns the indices of the leaves which differ between the two trees in ascending order.
+// The leaves beyond the size of the smaller tree are all considered different. Subtrees with the same hashes
+// are skipped, so finding k differing leaves takes O(k log n) hash comparisons. Both trees have to be built
+// with the same hashing function and options, otherwise all of their leaves differ.
+func Diff(a, b *MerkleTree) []int {
+	if a == b {
+		return nil
+	}
+	// the trees are always locked in the same order, so diffing them concurrently in both orders while they
+	// are being modified can't deadlock
+	first, second := a, b
+	if uintptr(unsafe.Pointer(second)) < uintptr(unsafe.Pointer(first)) {
+		first, second = second, first
+	}
+	first.mu.RLock()
+	defer first.mu.RUnlock()
+	second.mu.RLock()
+	defer second.mu.RUnlock()
+	return diffNodes(a.root, len(a.leaves), b.root, len(b.leaves), 0, nil)
+}
+
+// diffNodes compares the nodes covering aSize and bSize leaves starting at the leaf with index lo
+// and appends the indices of the differing leaves to diff.
+func diffNodes(a node, aSize int, b node, bSize int, lo int, diff []int) []int {
+	a, b = unwrapUnpaired(a, aSize), unwrapUnpaired(b, bSize)
+	if aSize < bSize {
+		// the comparison is symmetric, so the larger node always goes first
+		a, aSize, b, bSize = b, bSize, a, aSize
+	}
+	if aSize == bSize {
+		if bytes.Equal(a.Hash(), b.Hash()) {
+			return diff
+		}
+		if aSize == 1 {
+			return append(diff, lo)
+		}
+	}
+	powerOf2 := nearestSmallerPowerOf2(aSize)
+	aNode := a.(*nonLeaf)
+	if bSize <= powerOf2 {
+		// the right child of a covers only the leaves missing in b
+		diff = diffNodes(aNode.left, powerOf2, b, bSize, lo, diff)
+		for idx := lo + powerOf2; idx < lo+aSize; idx++ {
+			diff = append(diff, idx)
+		}
+		return diff
+	}
+	// both nodes are split at the same power of two
+	bNode := b.(*nonLeaf)
+	diff = diffNodes(aNode.left, powerOf2, bNode.left, powerOf2, lo, diff)
+	return diffNodes(aNode.right, aSize-powerOf2, bNode.right, bSize-powerOf2, lo+powerOf2, diff)
+}
+
+// unwrapUnpaired returns the child of a node covering size leaves which only wraps it, see buildRoot.
+func unwrapUnpaired(n node, size int) node {
+	for {
+		nl, ok := n.(*nonLeaf)
+		if !ok || !isUnpaired(nl, size) {
+			return n
+		}
+		n = nl.left
+	}
+}
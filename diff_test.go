@@ -0,0 +1,118 @@
+package merkletree
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for name, mode := range testModes {
+		for _, sizes := range [][2]int{{1, 1}, {1, 2}, {5, 5}, {6, 7}, {8, 8}, {9, 16}, {13, 4}, {33, 20}, {64, 65}} {
+			t.Run(fmt.Sprintf("%s %d and %d leaves", name, sizes[0], sizes[1]), func(t *testing.T) {
+				contents := make([][]byte, sizes[1])
+				for i := range contents {
+					contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+				}
+				modified := make([][]byte, sizes[0])
+				copy(modified, contents)
+				for i := len(contents); i < len(modified); i++ {
+					modified[i] = []byte(fmt.Sprintf("extra leaf %d", i))
+				}
+				for i := range modified {
+					if rnd.Intn(4) == 0 {
+						modified[i] = []byte(fmt.Sprintf("modified leaf %d", i))
+					}
+				}
+				a, err := NewMerkleTree(newLeaves(modified), SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				b, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+
+				var expected []int
+				for i := 0; i < len(modified) || i < len(contents); i++ {
+					if i >= len(modified) || i >= len(contents) || !bytes.Equal(modified[i], contents[i]) {
+						expected = append(expected, i)
+					}
+				}
+				assert.Equal(t, expected, Diff(a, b))
+				assert.Equal(t, expected, Diff(b, a))
+			})
+		}
+	}
+}
+
+func TestDiff_SameTree(t *testing.T) {
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	assert.Empty(t, Diff(tree, tree))
+
+	other, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	assert.Empty(t, Diff(tree, other))
+
+	require.NoError(t, other.Update(2, []byte("THREE")))
+	assert.Equal(t, []int{2}, Diff(tree, other))
+}
+
+// TestDiff_LockOrder checks that Diff locks the trees in the same order whatever the order of its arguments,
+// so diffing them concurrently in both orders while they are modified can't deadlock.
+func TestDiff_LockOrder(t *testing.T) {
+	a, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	b, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("2"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	first, second := a, b
+	if uintptr(unsafe.Pointer(b)) < uintptr(unsafe.Pointer(a)) {
+		first, second = b, a
+	}
+	for _, trees := range [][2]*MerkleTree{{a, b}, {b, a}} {
+		first.mu.Lock()
+		done := make(chan []int)
+		go func() {
+			done <- Diff(trees[0], trees[1])
+		}()
+		time.Sleep(10 * time.Millisecond)
+		// Diff waits for the first tree without holding the second one
+		assert.True(t, second.mu.TryLock())
+		second.mu.Unlock()
+		first.mu.Unlock()
+		assert.Equal(t, []int{1}, <-done)
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	contents := make([][]byte, 1<<20)
+	for i := range contents {
+		contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher)
+	require.NoError(b, err)
+	for i := 0; i < len(contents); i += len(contents) / 10 {
+		contents[i] = []byte("modified")
+	}
+	other, err := NewMerkleTree(newLeaves(contents), SHA256Hasher)
+	require.NoError(b, err)
+	b.Run("diff", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Diff(tree, other)
+		}
+	})
+	b.Run("leaf by leaf", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var diff []int
+			for j, leaf := range tree.leaves {
+				if !bytes.Equal(leaf.Hash(), other.leaves[j].Hash()) {
+					diff = append(diff, j)
+				}
+			}
+		}
+	})
+}
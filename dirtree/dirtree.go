@@ -0,0 +1,348 @@
+// Package dirtree hashes directories recursively into a Merkle DAG, much like git trees or IPFS UnixFS.
+//
+// The hash of a regular file is the hash of a leaf holding its content or, with WithChunkSize, the root hash
+// of a tree of its chunks. The hash of a symbolic link is the hash of a leaf holding its target. A directory
+// is a Merkle tree with a leaf for every entry in the order of their names, each leaf holding the name,
+// the mode and the hash of the entry, and its hash is the root hash of that tree. An empty directory is
+// hashed like a leaf without content. A Proof shows that a path is included in the root hash of a directory.
+package dirtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dogenkigen/merkletree"
+)
+
+// Mode is the type of an entry of a directory. The values are the ones used by git trees.
+type Mode uint32
+
+const (
+	ModeFile       Mode = 0o100644
+	ModeExecutable Mode = 0o100755
+	ModeSymlink    Mode = 0o120000
+	ModeDir        Mode = 0o040000
+)
+
+// Entry is an entry of a directory.
+type Entry struct {
+	Name string
+	Mode Mode
+	Hash []byte
+}
+
+// encode returns the content of the leaf of the entry in the tree of its directory.
+func (e Entry) encode() []byte {
+	data := make([]byte, 0, 2*binary.MaxVarintLen32+len(e.Name)+len(e.Hash))
+	data = binary.AppendUvarint(data, uint64(e.Mode))
+	data = binary.AppendUvarint(data, uint64(len(e.Name)))
+	data = append(data, e.Name...)
+	return append(data, e.Hash...)
+}
+
+// Option configures how a directory is hashed.
+type Option func(*options)
+
+type options struct {
+	chunkSize int
+	mode      merkletree.Mode
+}
+
+// WithChunkSize splits the content of files into chunks of the given size, so the hash of a file is the root hash
+// of a tree with a leaf for every chunk. Files are hashed as a single leaf by default.
+func WithChunkSize(size int) Option {
+	return func(o *options) {
+		o.chunkSize = size
+	}
+}
+
+// WithMode sets the mode of the trees of the directories and the chunked files.
+func WithMode(mode merkletree.Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// hasher hashes the files and the directories with a hashing function and options.
+type hasher struct {
+	hasher merkletree.Hasher
+	stream *merkletree.StreamHasher
+	opts   options
+}
+
+func newHasher(h merkletree.Hasher, opts []Option) *hasher {
+	return &hasher{hasher: h, stream: merkletree.NewStreamHasherFromHasher(h), opts: newOptions(opts)}
+}
+
+func (h *hasher) treeOptions() []merkletree.Option {
+	return []merkletree.Option{merkletree.WithMode(h.opts.mode)}
+}
+
+func (h *hasher) leafHash(r io.Reader) ([]byte, error) {
+	return merkletree.HashLeafFromReader(r, h.stream, h.treeOptions()...)
+}
+
+func (h *hasher) fileHash(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if h.opts.chunkSize == 0 {
+		return h.leafHash(f)
+	}
+	tree, err := merkletree.NewMerkleTreeFromReader(f, h.opts.chunkSize, h.hasher, h.treeOptions()...)
+	if errors.Is(err, merkletree.ErrEmptyTree) {
+		return h.leafHash(bytes.NewReader(nil))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tree.Hash(), nil
+}
+
+// HashFile returns the hash of a regular file, equal to the hash of its entry in a directory hashed
+// with the same hashing function and options. It can be used to check the file a Proof refers to.
+func HashFile(name string, hasher merkletree.Hasher, opts ...Option) ([]byte, error) {
+	return newHasher(hasher, opts).fileHash(name)
+}
+
+// Tree is a hashed directory. Paths are relative to the hashed directory and use slashes as separators.
+// The hashed directory itself is ".".
+type Tree struct {
+	hasher *hasher
+	root   *dir
+	dirs   map[string]*dir
+	// entries holds the entries of all the paths
+	entries map[string]Entry
+}
+
+// dir is a hashed directory with its entries sorted by name.
+type dir struct {
+	entries []Entry
+	// tree is nil if the directory is empty
+	tree *merkletree.MerkleTree
+	hash []byte
+}
+
+// HashDir hashes the directory recursively with the given hashing function. Symbolic links are not followed.
+// It returns an error wrapping ErrUnsupportedFileType if the directory holds anything but regular files,
+// directories and symbolic links.
+func HashDir(root string, hasher merkletree.Hasher, opts ...Option) (*Tree, error) {
+	t := &Tree{hasher: newHasher(hasher, opts), dirs: map[string]*dir{}, entries: map[string]Entry{}}
+	var err error
+	if t.root, err = t.hashDir(root, "."); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tree) hashDir(name, p string) (*dir, error) {
+	dirEntries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	d := &dir{entries: make([]Entry, 0, len(dirEntries))}
+	for _, de := range dirEntries {
+		entry, err := t.hashEntry(filepath.Join(name, de.Name()), path.Join(p, de.Name()), de)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = append(d.entries, entry)
+	}
+	if len(d.entries) == 0 {
+		if d.hash, err = t.hasher.leafHash(bytes.NewReader(nil)); err != nil {
+			return nil, err
+		}
+	} else {
+		leaves := make([]*merkletree.Leaf, len(d.entries))
+		for i, entry := range d.entries {
+			leaves[i] = merkletree.NewLeaf(entry.encode())
+		}
+		if d.tree, err = merkletree.NewMerkleTree(leaves, t.hasher.hasher, t.hasher.treeOptions()...); err != nil {
+			return nil, err
+		}
+		d.hash = d.tree.Hash()
+	}
+	t.dirs[p] = d
+	t.entries[p] = Entry{Name: path.Base(p), Mode: ModeDir, Hash: d.hash}
+	return d, nil
+}
+
+func (t *Tree) hashEntry(name, p string, de fs.DirEntry) (Entry, error) {
+	entry := Entry{Name: de.Name()}
+	switch typ := de.Type(); {
+	case typ.IsDir():
+		d, err := t.hashDir(name, p)
+		if err != nil {
+			return Entry{}, err
+		}
+		entry.Mode, entry.Hash = ModeDir, d.hash
+	case typ&fs.ModeSymlink != 0:
+		target, err := os.Readlink(name)
+		if err != nil {
+			return Entry{}, err
+		}
+		if entry.Hash, err = t.hasher.leafHash(strings.NewReader(filepath.ToSlash(target))); err != nil {
+			return Entry{}, err
+		}
+		entry.Mode = ModeSymlink
+	case typ.IsRegular():
+		info, err := de.Info()
+		if err != nil {
+			return Entry{}, err
+		}
+		if entry.Hash, err = t.hasher.fileHash(name); err != nil {
+			return Entry{}, err
+		}
+		entry.Mode = ModeFile
+		if info.Mode()&0o111 != 0 {
+			entry.Mode = ModeExecutable
+		}
+	default:
+		return Entry{}, fmt.Errorf("%s: %w", name, ErrUnsupportedFileType)
+	}
+	t.entries[p] = entry
+	return entry, nil
+}
+
+// Hash returns the root hash of the hashed directory.
+func (t *Tree) Hash() []byte {
+	return t.root.hash
+}
+
+// Lookup returns the entry of the path.
+func (t *Tree) Lookup(p string) (Entry, bool) {
+	entry, ok := t.entries[path.Clean(p)]
+	return entry, ok
+}
+
+// Hashes returns the hashes of all the paths, including the root hash of the hashed directory ".".
+func (t *Tree) Hashes() map[string][]byte {
+	hashes := make(map[string][]byte, len(t.entries))
+	for p, entry := range t.entries {
+		hashes[p] = entry.Hash
+	}
+	return hashes
+}
+
+// Entries returns the entries of the directory at the path sorted by name.
+func (t *Tree) Entries(p string) ([]Entry, error) {
+	d, ok := t.dirs[path.Clean(p)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", p, ErrNotFound)
+	}
+	return append([]Entry(nil), d.entries...), nil
+}
+
+// ProofStep proves that an entry is included in the hash of its directory.
+type ProofStep struct {
+	// Entry is the proven entry.
+	Entry Entry
+	// Proof is the proof of the leaf of the entry in the tree of the directory.
+	Proof *merkletree.Proof
+	// LeafCount is the number of entries of the directory.
+	LeafCount int
+}
+
+// Proof proves that a path is included in the root hash of a directory. It has a step for every component
+// of the path, starting with the entry of the hashed directory, so every step proves the entry whose hash
+// is the directory hash of the next step.
+type Proof struct {
+	Steps []ProofStep
+}
+
+// Path returns the proven path.
+func (p *Proof) Path() string {
+	names := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		names[i] = step.Entry.Name
+	}
+	return strings.Join(names, "/")
+}
+
+// Entry returns the entry of the proven path. The hash of a file can be compared with HashFile.
+func (p *Proof) Entry() Entry {
+	if len(p.Steps) == 0 {
+		return Entry{}
+	}
+	return p.Steps[len(p.Steps)-1].Entry
+}
+
+// GenerateProof creates a proof that the path is included in the root hash of the directory.
+// It returns ErrInvalidPath for the root itself and paths outside of it and ErrNotFound if the path doesn't exist.
+func (t *Tree) GenerateProof(p string) (*Proof, error) {
+	p = path.Clean(p)
+	if p == "." || path.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return nil, fmt.Errorf("%s: %w", p, ErrInvalidPath)
+	}
+	proof := &Proof{}
+	d := t.root
+	names := strings.Split(p, "/")
+	for i, name := range names {
+		if d == nil {
+			// the previous component is not a directory
+			return nil, fmt.Errorf("%s: %w", p, ErrNotFound)
+		}
+		idx := sort.Search(len(d.entries), func(i int) bool {
+			return d.entries[i].Name >= name
+		})
+		if idx == len(d.entries) || d.entries[idx].Name != name {
+			return nil, fmt.Errorf("%s: %w", p, ErrNotFound)
+		}
+		leafProof, err := d.tree.GenerateProof(idx)
+		if err != nil {
+			return nil, err
+		}
+		proof.Steps = append(proof.Steps, ProofStep{Entry: d.entries[idx], Proof: leafProof, LeafCount: len(d.entries)})
+		d = t.dirs[strings.Join(names[:i+1], "/")]
+	}
+	return proof, nil
+}
+
+// VerifyProof checks that the proof proves its path in a directory with the given root hash,
+// hashed with the given hashing function and options.
+func VerifyProof(proof *Proof, root []byte, hasher merkletree.Hasher, opts ...Option) error {
+	if len(proof.Steps) == 0 {
+		return ErrInvalidProof
+	}
+	h := newHasher(hasher, opts)
+	dirHash := root
+	for i, step := range proof.Steps {
+		if i > 0 && proof.Steps[i-1].Entry.Mode != ModeDir {
+			return fmt.Errorf("%s is not a directory: %w", proof.Steps[i-1].Entry.Name, ErrInvalidProof)
+		}
+		if step.Proof == nil || step.Entry.Name == "" || strings.Contains(step.Entry.Name, "/") {
+			return ErrInvalidProof
+		}
+		leafHash, err := h.leafHash(bytes.NewReader(step.Entry.encode()))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(leafHash, step.Proof.LeafHash()) {
+			return &merkletree.LeafHashMismatchError{Index: step.Proof.LeafIndex(), Provided: step.Proof.LeafHash(), Expected: leafHash}
+		}
+		if err := merkletree.VerifyProof(step.Proof, dirHash, hasher, step.LeafCount, h.treeOptions()...); err != nil {
+			return fmt.Errorf("%s: %w", step.Entry.Name, err)
+		}
+		dirHash = step.Entry.Hash
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package dirtree
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dogenkigen/merkletree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates the files with the given contents in a new temporary directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	}
+	return dir
+}
+
+var testFiles = map[string]string{
+	"README.md":         "readme",
+	"empty":             "",
+	"src/main.go":       "package main",
+	"src/util/util.go":  "package util",
+	"src/util/more.go":  "package util // more",
+	"docs/guide/one.md": "one",
+	"docs/guide/two.md": "two",
+}
+
+func TestHashDir(t *testing.T) {
+	dir := writeFiles(t, testFiles)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "empty dir"), 0o755))
+	tree, err := HashDir(dir, merkletree.SHA256Hasher)
+	require.NoError(t, err)
+
+	entry, ok := tree.Lookup("src/util/util.go")
+	require.True(t, ok)
+	assert.Equal(t, Entry{Name: "util.go", Mode: ModeFile, Hash: merkletree.SHA256Hasher([]byte("package util"))}, entry)
+	entry, ok = tree.Lookup("empty dir")
+	require.True(t, ok)
+	assert.Equal(t, Entry{Name: "empty dir", Mode: ModeDir, Hash: merkletree.SHA256Hasher(nil)}, entry)
+
+	// a directory is the tree of its sorted entries
+	util, err := HashDir(filepath.Join(dir, "src", "util"), merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	expected, err := merkletree.NewMerkleTree([]*merkletree.Leaf{
+		merkletree.NewLeaf(Entry{Name: "more.go", Mode: ModeFile, Hash: merkletree.SHA256Hasher([]byte("package util // more"))}.encode()),
+		merkletree.NewLeaf(Entry{Name: "util.go", Mode: ModeFile, Hash: merkletree.SHA256Hasher([]byte("package util"))}.encode()),
+	}, merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Hash(), util.Hash())
+	entry, _ = tree.Lookup("src/util")
+	assert.Equal(t, util.Hash(), entry.Hash)
+
+	hashes := tree.Hashes()
+	assert.Len(t, hashes, len(testFiles)+6)
+	assert.Equal(t, tree.Hash(), hashes["."])
+	entries, err := tree.Entries("src")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main.go", "util"}, []string{entries[0].Name, entries[1].Name})
+	_, err = tree.Entries("src/main.go")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// the hash only depends on the names, the modes and the contents
+	same, err := HashDir(writeFiles(t, testFiles), merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	assert.NotEqual(t, tree.Hash(), same.Hash())
+	require.NoError(t, os.Remove(filepath.Join(dir, "empty dir")))
+	tree, err = HashDir(dir, merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, same.Hash(), tree.Hash())
+
+	changes := map[string]func(dir string) error{
+		"content": func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "src", "util", "more.go"), []byte("changed"), 0o644)
+		},
+		"name": func(dir string) error {
+			return os.Rename(filepath.Join(dir, "docs", "guide", "one.md"), filepath.Join(dir, "docs", "guide", "three.md"))
+		},
+		"mode": func(dir string) error {
+			return os.Chmod(filepath.Join(dir, "empty"), 0o755)
+		},
+		"new file": func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "docs", "new"), nil, 0o644)
+		},
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, testFiles)
+			require.NoError(t, change(dir))
+			changed, err := HashDir(dir, merkletree.SHA256Hasher)
+			require.NoError(t, err)
+			assert.NotEqual(t, same.Hash(), changed.Hash())
+		})
+	}
+}
+
+func TestHashDir_Symlink(t *testing.T) {
+	dir := writeFiles(t, testFiles)
+	if err := os.Symlink("src/main.go", filepath.Join(dir, "link")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+	tree, err := HashDir(dir, merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	entry, ok := tree.Lookup("link")
+	require.True(t, ok)
+	assert.Equal(t, Entry{Name: "link", Mode: ModeSymlink, Hash: merkletree.SHA256Hasher([]byte("src/main.go"))}, entry)
+}
+
+func TestHashDir_ChunkSize(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"data": "0123456789", "empty": ""})
+	tree, err := HashDir(dir, merkletree.SHA256Hasher, WithChunkSize(4), WithMode(merkletree.RFC6962))
+	require.NoError(t, err)
+	chunked, err := merkletree.NewMerkleTree([]*merkletree.Leaf{
+		merkletree.NewLeaf([]byte("0123")),
+		merkletree.NewLeaf([]byte("4567")),
+		merkletree.NewLeaf([]byte("89")),
+	}, merkletree.SHA256Hasher, merkletree.WithMode(merkletree.RFC6962))
+	require.NoError(t, err)
+	entry, _ := tree.Lookup("data")
+	assert.Equal(t, chunked.Hash(), entry.Hash)
+	hash, err := HashFile(filepath.Join(dir, "data"), merkletree.SHA256Hasher, WithChunkSize(4), WithMode(merkletree.RFC6962))
+	require.NoError(t, err)
+	assert.Equal(t, chunked.Hash(), hash)
+	entry, _ = tree.Lookup("empty")
+	assert.Equal(t, merkletree.SHA256Hasher([]byte{0}), entry.Hash)
+}
+
+func TestTree_GenerateProof(t *testing.T) {
+	modes := map[string]merkletree.Mode{
+		"duplicate odd": merkletree.DuplicateOdd,
+		"rfc6962":       merkletree.RFC6962,
+		"sorted pair":   merkletree.SortedPair,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, testFiles)
+			tree, err := HashDir(dir, merkletree.SHA256Hasher, WithMode(mode))
+			require.NoError(t, err)
+			for p := range tree.Hashes() {
+				if p == "." {
+					continue
+				}
+				proof, err := tree.GenerateProof(p)
+				require.NoError(t, err)
+				assert.Equal(t, p, proof.Path())
+				entry, _ := tree.Lookup(p)
+				assert.Equal(t, entry, proof.Entry())
+				assert.NoError(t, VerifyProof(proof, tree.Hash(), merkletree.SHA256Hasher, WithMode(mode)), p)
+			}
+
+			proof, err := tree.GenerateProof("src/util/more.go")
+			require.NoError(t, err)
+			hash, err := HashFile(filepath.Join(dir, "src", "util", "more.go"), merkletree.SHA256Hasher, WithMode(mode))
+			require.NoError(t, err)
+			assert.Equal(t, hash, proof.Entry().Hash)
+		})
+	}
+}
+
+func TestVerifyProof_Errors(t *testing.T) {
+	tree, err := HashDir(writeFiles(t, testFiles), merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	valid := func() *Proof {
+		proof, err := tree.GenerateProof("src/util/util.go")
+		require.NoError(t, err)
+		return proof
+	}
+	testCases := map[string]func(proof *Proof){
+		"forged hash": func(proof *Proof) {
+			proof.Steps[2].Entry.Hash = merkletree.SHA256Hasher([]byte("forged"))
+		},
+		"renamed entry": func(proof *Proof) {
+			proof.Steps[2].Entry.Name = "other.go"
+		},
+		"changed mode": func(proof *Proof) {
+			proof.Steps[2].Entry.Mode = ModeExecutable
+		},
+		"file as directory": func(proof *Proof) {
+			proof.Steps[1].Entry.Mode = ModeFile
+		},
+		"missing step": func(proof *Proof) {
+			proof.Steps = proof.Steps[1:]
+		},
+		"wrong leaf count": func(proof *Proof) {
+			proof.Steps[0].LeafCount = 16
+		},
+		"no steps": func(proof *Proof) {
+			proof.Steps = nil
+		},
+	}
+	for name, modify := range testCases {
+		t.Run(name, func(t *testing.T) {
+			proof := valid()
+			modify(proof)
+			assert.Error(t, VerifyProof(proof, tree.Hash(), merkletree.SHA256Hasher))
+		})
+	}
+	assert.NoError(t, VerifyProof(valid(), tree.Hash(), merkletree.SHA256Hasher))
+	assert.Error(t, VerifyProof(valid(), tree.Hash(), merkletree.SHA512Hasher))
+}
+
+func TestTree_GenerateProof_Errors(t *testing.T) {
+	tree, err := HashDir(writeFiles(t, testFiles), merkletree.SHA256Hasher)
+	require.NoError(t, err)
+	for _, p := range []string{".", "", "/src", "..", "../src", "src/../../x"} {
+		_, err := tree.GenerateProof(p)
+		assert.ErrorIs(t, err, ErrInvalidPath, p)
+	}
+	for _, p := range []string{"missing", "src/missing.go", "README.md/x", "src/main.go/x"} {
+		_, err := tree.GenerateProof(p)
+		assert.ErrorIs(t, err, ErrNotFound, p)
+	}
+	_, err = HashDir(filepath.Join(t.TempDir(), "missing"), merkletree.SHA256Hasher)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func BenchmarkHashDir(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < 10; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%d", i))
+		require.NoError(b, os.Mkdir(sub, 0o755))
+		for j := 0; j < 100; j++ {
+			require.NoError(b, os.WriteFile(filepath.Join(sub, fmt.Sprintf("file%d", j)), make([]byte, 4096), 0o644))
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := HashDir(dir, merkletree.SHA256Hasher)
+		require.NoError(b, err)
+	}
+}
@@ -0,0 +1,11 @@
+package dirtree
+
+import "errors"
+
+// The errors returned when hashing directories and generating and verifying proofs.
+var (
+	ErrNotFound            = errors.New("dirtree: path not found in the tree")
+	ErrInvalidPath         = errors.New("dirtree: path has to be relative to the root and inside it")
+	ErrUnsupportedFileType = errors.New("dirtree: unsupported file type")
+	ErrInvalidProof        = errors.New("dirtree: proof doesn't describe a path")
+)
@@ -1,10 +1,113 @@
 package merkletree
 
-import "errors"
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
 
+// The errors returned by this package either are or wrap one of these errors, so they can be matched with errors.Is.
 var (
-	wrongProof          = errors.New("calculated hash doesn't match the root hash of the tree")
-	leafIndexOutOfBound = errors.New("provided leaf index doesn't exist")
-	leafHashMismatch    = errors.New("provided leaf hash doesn't match with hash of the leaf")
-	emptyTree           = errors.New("cannot create empty tree")
+	ErrWrongProof          = errors.New("calculated hash doesn't match the root hash of the tree")
+	ErrLeafIndexOutOfBound = errors.New("provided leaf index doesn't exist")
+	ErrLeafHashMismatch    = errors.New("provided leaf hash doesn't match with hash of the leaf")
+	ErrEmptyTree           = errors.New("cannot create empty tree")
+	ErrLeafRemoved         = errors.New("leaf at the provided index was removed")
+	ErrLeafNotFound        = errors.New("no leaf with the provided content or hash")
+
+	ErrNoLeafIndices         = errors.New("no leaf indices provided")
+	ErrInvalidTreeSize       = errors.New("provided tree sizes are not valid")
+	ErrWrongConsistencyProof = errors.New("consistency proof doesn't match the provided root hashes")
+
+	ErrTruncatedProof          = errors.New("proof encoding is truncated")
+	ErrOversizedProof          = errors.New("proof encoding exceeds the size limits")
+	ErrUnsupportedProofVersion = errors.New("unsupported proof encoding version")
+
+	ErrInvalidChunkSize = errors.New("chunk size must be positive")
+	ErrUnalignedRange   = errors.New("range of leaves is not aligned to its size")
+
+	ErrNoStoredTree   = errors.New("no tree stored in the node store")
+	ErrNodeNotFound   = errors.New("node not found in the node store")
+	ErrCorruptedStore = errors.New("node store is corrupted")
+
+	ErrInvalidSnapshot            = errors.New("invalid tree snapshot")
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported tree snapshot version")
+	ErrSnapshotHasherMismatch     = errors.New("hashing function doesn't match the one of the snapshot")
+	ErrSnapshotRootMismatch       = errors.New("root hash of the snapshot doesn't match its leaves")
 )
+
+// IndexOutOfRangeError is returned when a leaf index doesn't exist in a tree. It wraps ErrLeafIndexOutOfBound.
+type IndexOutOfRangeError struct {
+	Index int
+	Size  int
+}
+
+func (e *IndexOutOfRangeError) Error() string {
+	return fmt.Sprintf("%s: index %d, tree size %d", ErrLeafIndexOutOfBound, e.Index, e.Size)
+}
+
+func (e *IndexOutOfRangeError) Unwrap() error {
+	return ErrLeafIndexOutOfBound
+}
+
+// RemovedLeafError is returned when a leaf index refers to a leaf removed from a tree. It wraps ErrLeafRemoved.
+type RemovedLeafError struct {
+	Index int
+}
+
+func (e *RemovedLeafError) Error() string {
+	return fmt.Sprintf("%s: index %d", ErrLeafRemoved, e.Index)
+}
+
+func (e *RemovedLeafError) Unwrap() error {
+	return ErrLeafRemoved
+}
+
+// LeafHashMismatchError is returned when the hash of a leaf in a proof differs from the hash
+// of the leaf in the tree. It wraps ErrLeafHashMismatch.
+type LeafHashMismatchError struct {
+	Index    int
+	Provided []byte
+	Expected []byte
+}
+
+func (e *LeafHashMismatchError) Error() string {
+	return fmt.Sprintf("%s: index %d, provided %s, expected %s",
+		ErrLeafHashMismatch, e.Index, hex.EncodeToString(e.Provided), hex.EncodeToString(e.Expected))
+}
+
+func (e *LeafHashMismatchError) Unwrap() error {
+	return ErrLeafHashMismatch
+}
+
+// RootMismatchError is returned when the root hash calculated from a proof differs from the expected one.
+// It wraps ErrWrongProof.
+type RootMismatchError struct {
+	Computed []byte
+	Expected []byte
+}
+
+func (e *RootMismatchError) Error() string {
+	return fmt.Sprintf("%s: computed %s, expected %s",
+		ErrWrongProof, hex.EncodeToString(e.Computed), hex.EncodeToString(e.Expected))
+}
+
+func (e *RootMismatchError) Unwrap() error {
+	return ErrWrongProof
+}
+
+// SnapshotRootMismatchError is returned when the root hash calculated from the leaves of a snapshot
+// differs from the root hash stored in it. It wraps ErrSnapshotRootMismatch.
+type SnapshotRootMismatchError struct {
+	Computed []byte
+	Stored   []byte
+}
+
+func (e *SnapshotRootMismatchError) Error() string {
+	return fmt.Sprintf("%s: computed %s, stored %s",
+		ErrSnapshotRootMismatch, hex.EncodeToString(e.Computed), hex.EncodeToString(e.Stored))
+}
+
+func (e *SnapshotRootMismatchError) Unwrap() error {
+	return ErrSnapshotRootMismatch
+}
@@ -0,0 +1,258 @@
+package merkletree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	fileStoreLogName   = "nodes.log"
+	fileStoreIndexName = "nodes.idx"
+	fileStoreRootName  = "root"
+	// fileStoreIndexEntrySize is the size of an index entry holding the offset and the length of a node in the log.
+	fileStoreIndexEntrySize = 12
+	fileStoreRootVersion    = 1
+)
+
+var fileStoreRootMagic = []byte("MTRT")
+
+// FileNodeStore is a NodeStore keeping the nodes in files in a directory. The nodes are appended to a log
+// and their positions in the log are kept in an index of fixed-size entries, so reading a node takes two reads.
+// The root of the last stored tree is kept in a separate file which is replaced atomically once all the nodes
+// of the tree are synced to disk, so a tree interrupted while being saved is never returned by Root.
+type FileNodeStore struct {
+	mu          sync.Mutex
+	log         *os.File
+	index       *os.File
+	logWriter   *bufio.Writer
+	indexWriter *bufio.Writer
+	dir         string
+	logSize     int64
+	count       uint64
+}
+
+// OpenFileNodeStore opens the store in the directory, creating it if it doesn't exist.
+// Nodes written after the last complete index entry, e.g. by an interrupted write, are discarded.
+func OpenFileNodeStore(dir string) (*FileNodeStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(filepath.Join(dir, fileStoreLogName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(filepath.Join(dir, fileStoreIndexName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	s := &FileNodeStore{log: log, index: index, dir: dir}
+	if err := s.recover(); err != nil {
+		log.Close()
+		index.Close()
+		return nil, err
+	}
+	s.logWriter = bufio.NewWriter(log)
+	s.indexWriter = bufio.NewWriter(index)
+	return s, nil
+}
+
+// recover drops incomplete entries at the end of the files and positions them for appending.
+// The index entries of nodes whose records didn't reach the log are dropped as well.
+func (s *FileNodeStore) recover() error {
+	info, err := s.index.Stat()
+	if err != nil {
+		return err
+	}
+	logInfo, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	for s.count = uint64(info.Size() / fileStoreIndexEntrySize); s.count > 0; s.count-- {
+		offset, length, err := s.readIndexEntry(NodeID(s.count))
+		if err != nil {
+			return err
+		}
+		if offset+length <= logInfo.Size() {
+			s.logSize = offset + length
+			break
+		}
+	}
+	if err := s.index.Truncate(int64(s.count) * fileStoreIndexEntrySize); err != nil {
+		return err
+	}
+	if err := s.log.Truncate(s.logSize); err != nil {
+		return err
+	}
+	if _, err := s.index.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	_, err = s.log.Seek(0, io.SeekEnd)
+	return err
+}
+
+// PutNode appends the node to the log and returns its ID.
+func (s *FileNodeStore) PutNode(node StoredNode) (NodeID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := make([]byte, 0, 3*binary.MaxVarintLen64+len(node.Hash))
+	record = appendHash(record, node.Hash)
+	record = binary.AppendUvarint(record, uint64(node.Left))
+	record = binary.AppendUvarint(record, uint64(node.Right))
+	if _, err := s.logWriter.Write(record); err != nil {
+		return 0, err
+	}
+	var entry [fileStoreIndexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[:8], uint64(s.logSize))
+	binary.BigEndian.PutUint32(entry[8:], uint32(len(record)))
+	if _, err := s.indexWriter.Write(entry[:]); err != nil {
+		return 0, err
+	}
+	s.logSize += int64(len(record))
+	s.count++
+	return NodeID(s.count), nil
+}
+
+// GetNode reads the node with the given ID from the log.
+func (s *FileNodeStore) GetNode(id NodeID) (StoredNode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == 0 || uint64(id) > s.count {
+		return StoredNode{}, ErrNodeNotFound
+	}
+	if err := s.flush(); err != nil {
+		return StoredNode{}, err
+	}
+	offset, length, err := s.readIndexEntry(id)
+	if err != nil {
+		return StoredNode{}, err
+	}
+	record := make([]byte, length)
+	if _, err := s.log.ReadAt(record, offset); err != nil {
+		return StoredNode{}, ErrCorruptedStore
+	}
+	hash, record, err := readHash(record)
+	if err != nil {
+		return StoredNode{}, ErrCorruptedStore
+	}
+	left, record, err := readUvarint(record, math.MaxUint64)
+	if err != nil {
+		return StoredNode{}, ErrCorruptedStore
+	}
+	right, record, err := readUvarint(record, math.MaxUint64)
+	if err != nil || len(record) > 0 {
+		return StoredNode{}, ErrCorruptedStore
+	}
+	return StoredNode{Hash: hash, Left: NodeID(left), Right: NodeID(right)}, nil
+}
+
+func (s *FileNodeStore) readIndexEntry(id NodeID) (int64, int64, error) {
+	var entry [fileStoreIndexEntrySize]byte
+	if _, err := s.index.ReadAt(entry[:], int64(id-1)*fileStoreIndexEntrySize); err != nil {
+		return 0, 0, ErrCorruptedStore
+	}
+	offset := binary.BigEndian.Uint64(entry[:8])
+	if offset > math.MaxInt64 {
+		return 0, 0, ErrCorruptedStore
+	}
+	return int64(offset), int64(binary.BigEndian.Uint32(entry[8:])), nil
+}
+
+// SetRoot syncs the stored nodes to disk and then records the root of the tree.
+func (s *FileNodeStore) SetRoot(id NodeID, leafCount int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == 0 || uint64(id) > s.count {
+		return ErrNodeNotFound
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+	data := append([]byte{}, fileStoreRootMagic...)
+	data = append(data, fileStoreRootVersion)
+	data = binary.AppendUvarint(data, uint64(id))
+	data = binary.AppendUvarint(data, uint64(leafCount))
+	tmp := filepath.Join(s.dir, fileStoreRootName+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, fileStoreRootName))
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Root returns the root of the last tree recorded with SetRoot.
+func (s *FileNodeStore) Root() (NodeID, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(filepath.Join(s.dir, fileStoreRootName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, ErrNoStoredTree
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if !bytes.HasPrefix(data, fileStoreRootMagic) || len(data) < len(fileStoreRootMagic)+1 ||
+		data[len(fileStoreRootMagic)] != fileStoreRootVersion {
+		return 0, 0, ErrCorruptedStore
+	}
+	data = data[len(fileStoreRootMagic)+1:]
+	id, data, err := readUvarint(data, s.count)
+	if err != nil || id == 0 {
+		return 0, 0, ErrCorruptedStore
+	}
+	leafCount, data, err := readUvarint(data, math.MaxInt)
+	if err != nil || len(data) > 0 {
+		return 0, 0, ErrCorruptedStore
+	}
+	return NodeID(id), int(leafCount), nil
+}
+
+// Close writes the buffered nodes and closes the files of the store.
+func (s *FileNodeStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.flush()
+	if closeErr := s.log.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := s.index.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *FileNodeStore) flush() error {
+	if err := s.logWriter.Flush(); err != nil {
+		return err
+	}
+	return s.indexWriter.Flush()
+}
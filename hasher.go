@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
 // Hasher is a function type that represents a hashing function. It takes a byte slice
@@ -41,6 +42,28 @@ var (
 		return result[:]
 	}
 
+	// Keccak256Hasher is a Hasher that implements the Keccak-256 hash algorithm used by Ethereum,
+	// which differs from SHA3-256 in padding. It returns the Keccak-256 hash of the input data as a byte slice.
+	Keccak256Hasher = func(data []byte) []byte {
+		h := sha3.NewLegacyKeccak256()
+		h.Write(data)
+		return h.Sum(nil)
+	}
+
+	// SHA3_256Hasher is a Hasher that implements the SHA3-256 hash algorithm defined by FIPS 202.
+	// It returns the SHA3-256 hash of the input data as a byte slice.
+	SHA3_256Hasher = func(data []byte) []byte {
+		result := sha3.Sum256(data)
+		return result[:]
+	}
+
+	// SHA3_512Hasher is a Hasher that implements the SHA3-512 hash algorithm defined by FIPS 202.
+	// It returns the SHA3-512 hash of the input data as a byte slice.
+	SHA3_512Hasher = func(data []byte) []byte {
+		result := sha3.Sum512(data)
+		return result[:]
+	}
+
 	// MD5Hasher is a Hasher that implements the MD5 hash algorithm.
 	// It returns the MD5 hash of the input data as a byte slice.
 	MD5Hasher = func(data []byte) []byte {
@@ -48,3 +71,28 @@ var (
 		return result[:]
 	}
 )
+
+// NewSHAKE128Hasher returns a Hasher that implements the SHAKE128 extendable-output function
+// and produces digests of the given size in bytes. At least 32 bytes of output are needed
+// to get the full 128-bit security strength. It panics if size is not positive.
+func NewSHAKE128Hasher(size int) Hasher {
+	return newSHAKEHasher(size, sha3.ShakeSum128)
+}
+
+// NewSHAKE256Hasher returns a Hasher that implements the SHAKE256 extendable-output function
+// and produces digests of the given size in bytes. At least 64 bytes of output are needed
+// to get the full 256-bit security strength. It panics if size is not positive.
+func NewSHAKE256Hasher(size int) Hasher {
+	return newSHAKEHasher(size, sha3.ShakeSum256)
+}
+
+func newSHAKEHasher(size int, sum func(hash, data []byte)) Hasher {
+	if size <= 0 {
+		panic("merkletree: SHAKE output size must be positive")
+	}
+	return func(data []byte) []byte {
+		result := make([]byte, size)
+		sum(result, data)
+		return result
+	}
+}
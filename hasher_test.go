@@ -0,0 +1,132 @@
+package merkletree
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasher_KnownAnswers(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hasher Hasher
+		input  string
+		want   string
+	}{
+		{
+			name:   "keccak256 empty",
+			hasher: Keccak256Hasher,
+			input:  "",
+			want:   "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470",
+		},
+		{
+			name:   "keccak256 abc",
+			hasher: Keccak256Hasher,
+			input:  "abc",
+			want:   "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45",
+		},
+		{
+			name:   "sha3-256 empty",
+			hasher: SHA3_256Hasher,
+			input:  "",
+			want:   "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a",
+		},
+		{
+			name:   "sha3-256 abc",
+			hasher: SHA3_256Hasher,
+			input:  "abc",
+			want:   "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+		},
+		{
+			name:   "sha3-512 abc",
+			hasher: SHA3_512Hasher,
+			input:  "abc",
+			want: "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e" +
+				"10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0",
+		},
+		{
+			name:   "shake128 empty 32 bytes",
+			hasher: NewSHAKE128Hasher(32),
+			input:  "",
+			want:   "7f9c2ba4e88f827d616045507605853ed73b8093f6efbc88eb1a6eacfa66ef26",
+		},
+		{
+			name:   "shake256 empty 64 bytes",
+			hasher: NewSHAKE256Hasher(64),
+			input:  "",
+			want: "46b9dd2b0ba88d13233b3feb743eeb243fcd52ea62b81b82b50c27646ed5762f" +
+				"d75dc4ddd8c0f200cb05019d67b592f6fc821c49479ab48640292eacb3b7c4be",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, hex.EncodeToString(tc.hasher([]byte(tc.input))))
+		})
+	}
+}
+
+func TestNewSHAKEHasher_Size(t *testing.T) {
+	assert.Len(t, NewSHAKE128Hasher(16)([]byte("abc")), 16)
+	assert.Len(t, NewSHAKE256Hasher(100)([]byte("abc")), 100)
+	// A shorter SHAKE digest is a prefix of a longer one for the same input.
+	assert.Equal(t, NewSHAKE256Hasher(64)([]byte("abc"))[:32], NewSHAKE256Hasher(32)([]byte("abc")))
+	assert.Panics(t, func() { NewSHAKE128Hasher(0) })
+}
+
+// TestMerkleTree_Hash_SHA3 checks the roots of the tree of the leaves "one", "two" and "three"
+// against known answers calculated with independent implementations of the hashing functions.
+func TestMerkleTree_Hash_SHA3(t *testing.T) {
+	testCases := []struct {
+		name   string
+		hasher Hasher
+		root   string
+	}{
+		{
+			name:   "keccak256",
+			hasher: Keccak256Hasher,
+			root:   "c2fee6cbf98a3b96176c83491405a8cfbcd7d843fc043aceca56900830edb4b4",
+		},
+		{
+			name:   "sha3-256",
+			hasher: SHA3_256Hasher,
+			root:   "b80125d9d9c947d7f2faa0c48aa6c5664e5db41c020c28219ad764e09262d55d",
+		},
+		{
+			name:   "sha3-512",
+			hasher: SHA3_512Hasher,
+			root: "3032570bb73c7c41becb6610b1688a4f0d9da2dd7998a8c6d71f75fd6b696d0d" +
+				"8c6cf52efa1926e3f66cd561cbc9c50b304dba3fcd4e488dcb38b13d2bd02d5d",
+		},
+		{
+			name:   "shake128",
+			hasher: NewSHAKE128Hasher(32),
+			root:   "ff7c6026c578b63d63360c80f3d711e71ab1a9fc6f97b93e8caa60bf3433c169",
+		},
+		{
+			name:   "shake256",
+			hasher: NewSHAKE256Hasher(64),
+			root: "f0c3149c2280f54064443ab9465960c78983636f99220ed2e1ee508fb7dfbfe7" +
+				"13e198c7340c9dec751cc58adf2755a44af85cbf4787da77d3e677b16bc1a642",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			leaves := []*Leaf{
+				NewLeaf([]byte("one")),
+				NewLeaf([]byte("two")),
+				NewLeaf([]byte("three")),
+			}
+			tree, err := NewMerkleTree(leaves, tc.hasher)
+			require.NoError(t, err)
+			assert.Equal(t, tc.root, hex.EncodeToString(tree.Hash()))
+
+			for i := range leaves {
+				proof, err := tree.GenerateProof(i)
+				require.NoError(t, err)
+				assert.NoError(t, tree.VerifyProof(proof))
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package merkletree
+
+import (
+	"bytes"
+	"sort"
+)
+
+// leafIndex maps the hashes of the leaves of a tree to their indices in ascending order.
+type leafIndex map[string][]int
+
+// buildIndex indexes all the leaves if the tree is built with WithLeafIndex.
+func (mt *MerkleTree) buildIndex() {
+	if !mt.opts.leafIndex {
+		return
+	}
+	mt.index = make(leafIndex, len(mt.leaves))
+	for idx := range mt.leaves {
+		mt.indexLeaf(idx)
+	}
+}
+
+// indexLeaf adds the leaf at the index to the index of the tree. Removed leaves are not indexed.
+func (mt *MerkleTree) indexLeaf(idx int) {
+	leaf := mt.leaves[idx]
+	if mt.index == nil || leaf.isRemoved() {
+		return
+	}
+	key := string(leaf.Hash())
+	indices := mt.index[key]
+	i := sort.SearchInts(indices, idx)
+	if i < len(indices) && indices[i] == idx {
+		return
+	}
+	indices = append(indices, 0)
+	copy(indices[i+1:], indices[i:])
+	indices[i] = idx
+	mt.index[key] = indices
+}
+
+// unindexLeaf removes the leaf at the index from the index of the tree. It has to be called before the leaf changes.
+func (mt *MerkleTree) unindexLeaf(idx int) {
+	leaf := mt.leaves[idx]
+	if mt.index == nil || leaf.isRemoved() {
+		return
+	}
+	key := string(leaf.Hash())
+	indices := mt.index[key]
+	i := sort.SearchInts(indices, idx)
+	if i == len(indices) || indices[i] != idx {
+		return
+	}
+	if len(indices) == 1 {
+		delete(mt.index, key)
+		return
+	}
+	mt.index[key] = append(indices[:i], indices[i+1:]...)
+}
+
+// IndexOf returns the lowest index of a leaf with the given hash. Removed leaves are never found.
+// Without WithLeafIndex the leaves are scanned, which takes O(n) comparisons.
+func (mt *MerkleTree) IndexOf(hash []byte) (int, bool) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	return mt.indexOf(hash)
+}
+
+func (mt *MerkleTree) indexOf(hash []byte) (int, bool) {
+	if mt.index != nil {
+		if indices := mt.index[string(hash)]; len(indices) > 0 {
+			return indices[0], true
+		}
+		return 0, false
+	}
+	for idx, leaf := range mt.leaves {
+		if bytes.Equal(leaf.Hash(), hash) && !leaf.isRemoved() {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// IndicesOf returns the indices of all the leaves with the given hash in ascending order,
+// so leaves with the same content are all found. It returns nil if there is no such leaf.
+func (mt *MerkleTree) IndicesOf(hash []byte) []int {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	if mt.index != nil {
+		return append([]int(nil), mt.index[string(hash)]...)
+	}
+	var indices []int
+	for idx, leaf := range mt.leaves {
+		if bytes.Equal(leaf.Hash(), hash) && !leaf.isRemoved() {
+			indices = append(indices, idx)
+		}
+	}
+	return indices
+}
+
+// ProofForContent creates a proof for the leaf with the given content. If several leaves have the same content,
+// the proof is created for the one with the lowest index, see IndicesOf. It returns ErrLeafNotFound
+// if there is no such leaf.
+func (mt *MerkleTree) ProofForContent(content []byte) (*Proof, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	idx, ok := mt.indexOf(mt.opts.mode.leafHasher(mt.hasher)(content))
+	if !ok {
+		return nil, ErrLeafNotFound
+	}
+	return mt.generateProof(idx)
+}
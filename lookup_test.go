@@ -0,0 +1,105 @@
+package merkletree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleTree_IndexOf(t *testing.T) {
+	options := map[string][]Option{
+		"scan":               nil,
+		"leaf index":         {WithLeafIndex()},
+		"leaf index rfc6962": {WithLeafIndex(), WithMode(RFC6962)},
+	}
+	for name, opts := range options {
+		t.Run(name, func(t *testing.T) {
+			contents := [][]byte{[]byte("a"), []byte("b"), []byte("a"), []byte("c"), []byte("a")}
+			tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, opts...)
+			require.NoError(t, err)
+			leafHash := func(content string) []byte {
+				return newOptions(opts).mode.leafHasher(Hasher(SHA256Hasher))([]byte(content))
+			}
+
+			idx, ok := tree.IndexOf(leafHash("c"))
+			assert.True(t, ok)
+			assert.Equal(t, 3, idx)
+			idx, ok = tree.IndexOf(leafHash("a"))
+			assert.True(t, ok)
+			assert.Equal(t, 0, idx)
+			assert.Equal(t, []int{0, 2, 4}, tree.IndicesOf(leafHash("a")))
+			_, ok = tree.IndexOf(leafHash("d"))
+			assert.False(t, ok)
+			assert.Nil(t, tree.IndicesOf(leafHash("d")))
+
+			proof, err := tree.ProofForContent([]byte("c"))
+			require.NoError(t, err)
+			assert.Equal(t, 3, proof.LeafIndex())
+			assert.NoError(t, tree.VerifyProof(proof))
+			_, err = tree.ProofForContent([]byte("d"))
+			assert.ErrorIs(t, err, ErrLeafNotFound)
+
+			// the lookups follow the modifications of the tree
+			tree.Append(NewLeaf([]byte("d")), NewLeaf([]byte("a")))
+			assert.Equal(t, []int{0, 2, 4, 6}, tree.IndicesOf(leafHash("a")))
+			idx, _ = tree.IndexOf(leafHash("d"))
+			assert.Equal(t, 5, idx)
+			require.NoError(t, tree.Update(0, []byte("d")))
+			assert.Equal(t, []int{2, 4, 6}, tree.IndicesOf(leafHash("a")))
+			assert.Equal(t, []int{0, 5}, tree.IndicesOf(leafHash("d")))
+			require.NoError(t, tree.Remove(2))
+			assert.Equal(t, []int{4, 6}, tree.IndicesOf(leafHash("a")))
+			_, ok = tree.IndexOf(make([]byte, 32))
+			assert.False(t, ok)
+			require.NoError(t, tree.Update(2, []byte("a")))
+			assert.Equal(t, []int{2, 4, 6}, tree.IndicesOf(leafHash("a")))
+			require.NoError(t, tree.Truncate(4))
+			assert.Equal(t, []int{2}, tree.IndicesOf(leafHash("a")))
+			_, ok = tree.IndexOf(leafHash("d"))
+			assert.True(t, ok)
+			assert.Equal(t, []int{0}, tree.IndicesOf(leafHash("d")))
+
+			proof, err = tree.ProofForContent([]byte("a"))
+			require.NoError(t, err)
+			assert.Equal(t, 2, proof.LeafIndex())
+			assert.NoError(t, tree.VerifyProof(proof))
+		})
+	}
+}
+
+func TestLoadMerkleTree_WithLeafIndex(t *testing.T) {
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	store := NewMemoryNodeStore()
+	require.NoError(t, tree.Save(store))
+	loaded, err := LoadMerkleTree(store, SHA256Hasher, WithLeafIndex())
+	require.NoError(t, err)
+	idx, ok := loaded.IndexOf(SHA256Hasher([]byte("three")))
+	assert.True(t, ok)
+	assert.Equal(t, 2, idx)
+}
+
+func BenchmarkMerkleTree_IndexOf(b *testing.B) {
+	const size = 1 << 16
+	contents := make([][]byte, size)
+	for i := range contents {
+		contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	hash := SHA256Hasher(contents[size-1])
+	options := map[string][]Option{
+		"scan":       nil,
+		"leaf index": {WithLeafIndex()},
+	}
+	for name, opts := range options {
+		b.Run(name, func(b *testing.B) {
+			tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, opts...)
+			require.NoError(b, err)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.IndexOf(hash)
+			}
+		})
+	}
+}
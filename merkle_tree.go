@@ -2,55 +2,204 @@ package merkletree
 
 import (
 	"bytes"
+	"math/bits"
 	"regexp"
+	"sync"
 )
 
 // MerkleTree represents a Merkle tree data structure.
+//
+// A MerkleTree is safe for concurrent use by multiple readers (Hash, GenerateProof, VerifyProof, String
+// and the other methods which don't modify the tree) and a single writer (Append, Update, UpdateMany, Remove
+// and Truncate).
+// All hashes are calculated eagerly when the tree is modified, so readers never write to the tree.
+// Leaves passed to the tree must not be modified by the caller afterwards.
 type MerkleTree struct {
+	mu     sync.RWMutex
 	root   node
 	leaves []*Leaf
-	hasher Hasher
+	hasher sumHasher
+	opts   options
+	// frontier holds the roots of the complete subtrees on the right edge of the tree, the largest first.
+	// Their sizes follow the binary representation of the number of leaves.
+	frontier []node
+	// index maps the hashes of the leaves to their indices if the tree is built with WithLeafIndex
+	index leafIndex
+	// savedTo is the store the tree was last saved to or loaded from, whose IDs the nodes keep
+	savedTo NodeStore
 }
 
-// NewMerkleTree creates a new Merkle tree given a set of leaves and a hashing function.
-func NewMerkleTree(leaves []*Leaf, hasher Hasher) (*MerkleTree, error) {
+// NewMerkleTree creates a new Merkle tree given a set of leaves, a hashing function and optional settings.
+func NewMerkleTree(leaves []*Leaf, hasher Hasher, opts ...Option) (*MerkleTree, error) {
 	if len(leaves) == 0 {
-		return nil, emptyTree
+		return nil, ErrEmptyTree
 	}
+	o := newOptions(opts)
+	h := o.sumHasher(hasher)
+	leafHasher := o.mode.leafHasher(h)
 	for _, l := range leaves {
-		l.hashFunc = hasher
+		l.hashFunc = leafHasher
+		l.storedID = 0
 	}
-	return &MerkleTree{leaves: leaves, hasher: hasher, root: buildRoot(leaves, hasher)}, nil
+	hashNodes(leaves, o.workers)
+	mt := &MerkleTree{leaves: leaves, hasher: h, opts: o, root: buildRoot(leaves, h, o)}
+	mt.frontier = mt.collectFrontier()
+	mt.root.Hash()
+	mt.buildIndex()
+	return mt, nil
 }
 
 // VerifyProof checks the provided proof against the Merkle tree.
 // It returns an error if the proof is invalid or doesn't correspond to any leaf in the tree.
 func (mt *MerkleTree) VerifyProof(proof *Proof) error {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
 	if proof.leafIndex < 0 || proof.leafIndex >= len(mt.leaves) {
-		return leafIndexOutOfBound
+		return &IndexOutOfRangeError{Index: proof.leafIndex, Size: len(mt.leaves)}
 	}
-	currentHash := proof.leafHash
-	if !bytes.Equal(currentHash, mt.leaves[proof.leafIndex].Hash()) {
-		return leafHashMismatch
+	if mt.leaves[proof.leafIndex].isRemoved() {
+		return &RemovedLeafError{Index: proof.leafIndex}
+	}
+	if leafHash := mt.leaves[proof.leafIndex].Hash(); !bytes.Equal(proof.leafHash, leafHash) {
+		return &LeafHashMismatchError{Index: proof.leafIndex, Provided: proof.leafHash, Expected: leafHash}
+	}
+	return verifyProof(proof, mt.root.Hash(), mt.hasher, len(mt.leaves), mt.opts)
+}
+
+// VerifyProof checks the provided proof against the root hash of a tree with leafCount leaves
+// built with the given hashing function. Unlike MerkleTree.VerifyProof it doesn't need the tree
+// itself, so it can be used by clients that only know the root hash and the number of leaves.
+// The options have to match the ones the tree was built with.
+func VerifyProof(proof *Proof, root []byte, hasher Hasher, leafCount int, opts ...Option) error {
+	o := newOptions(opts)
+	return verifyProof(proof, root, o.sumHasher(hasher), leafCount, o)
+}
+
+func verifyProof(proof *Proof, root []byte, hasher sumHasher, leafCount int, o options) error {
+	if proof.leafIndex < 0 || proof.leafIndex >= leafCount {
+		return &IndexOutOfRangeError{Index: proof.leafIndex, Size: leafCount}
+	}
+	if isTombstone(proof.leafHash) {
+		return &RemovedLeafError{Index: proof.leafIndex}
+	}
+	var calculated []byte
+	switch {
+	case o.mode == SortedPair:
+		calculated = calculateSortedPairRootHash(proof, o.mode.nodeHasher(hasher))
+	case o.mode.promotesOdd():
+		calculated = calculatePromotedRootHash(proof, o.mode.nodeHasher(hasher), leafCount)
+	default:
+		calculated = calculateRootHash(proof, o.mode.nodeHasher(hasher), leafCount)
+	}
+	if calculated == nil {
+		// the proof doesn't have the shape of a tree with leafCount leaves
+		return ErrWrongProof
+	}
+	if !bytes.Equal(calculated, root) {
+		return &RootMismatchError{Computed: calculated, Expected: root}
+	}
+	return nil
+}
+
+// calculateRootHash folds the sibling hashes of the proof into the root hash.
+// An unpaired node is hashed with its own copy (see buildRoot), so the sibling
+// provided for it has to be equal to the hash calculated so far.
+// It returns nil if the proof doesn't match the shape of a tree with leafCount leaves.
+func calculateRootHash(proof *Proof, hasher pairHasher, leafCount int) []byte {
+	if len(proof.siblingHashes) != treeDepth(leafCount) {
+		return nil
 	}
+	currentHash := proof.leafHash
+	lastIdx := leafCount - 1
 	for i, sibling := range proof.siblingHashes {
 		if (proof.leafIndex>>i)&1 == 0 {
-			currentHash = mt.hasher(append(currentHash, sibling...))
+			if proof.leafIndex>>i == lastIdx>>i && !bytes.Equal(currentHash, sibling) {
+				return nil
+			}
+			currentHash = hasher(currentHash, sibling)
+		} else {
+			currentHash = hasher(sibling, currentHash)
+		}
+	}
+	return currentHash
+}
+
+// calculatePromotedRootHash folds the sibling hashes of the proof into the root hash of a tree
+// in which an unpaired node is promoted to the next level, following RFC 9162 section 2.1.3.2.
+// It returns nil if the proof doesn't match the shape of a tree with leafCount leaves.
+func calculatePromotedRootHash(proof *Proof, hasher pairHasher, leafCount int) []byte {
+	currentHash := proof.leafHash
+	idx, lastIdx := proof.leafIndex, leafCount-1
+	for _, sibling := range proof.siblingHashes {
+		if lastIdx == 0 {
+			return nil
+		}
+		if idx&1 == 1 || idx == lastIdx {
+			currentHash = hasher(sibling, currentHash)
+			for idx&1 == 0 && idx != 0 {
+				idx >>= 1
+				lastIdx >>= 1
+			}
 		} else {
-			currentHash = mt.hasher(append(sibling, currentHash...))
+			currentHash = hasher(currentHash, sibling)
 		}
+		idx >>= 1
+		lastIdx >>= 1
 	}
-	if !bytes.Equal(currentHash, mt.root.Hash()) {
-		return wrongProof
+	if lastIdx != 0 {
+		return nil
+	}
+	return currentHash
+}
+
+// calculateSortedPairRootHash folds the sibling hashes of the proof into the root hash of a tree
+// built in the SortedPair mode, in which the order of the children doesn't depend on the leaf index.
+// It is the equivalent of OpenZeppelin MerkleProof.processProof.
+func calculateSortedPairRootHash(proof *Proof, hasher pairHasher) []byte {
+	currentHash := proof.leafHash
+	for _, sibling := range proof.siblingHashes {
+		currentHash = hasher(currentHash, sibling)
+	}
+	return currentHash
+}
+
+// VerifySortedPairProof checks a proof of a tree built in the SortedPair mode against the root hash,
+// like OpenZeppelin MerkleProof.verify does. Neither the leaf index nor the number of leaves is needed.
+// A proof of a removed leaf is rejected.
+func VerifySortedPairProof(proof *Proof, root []byte, hasher Hasher) error {
+	if isTombstone(proof.leafHash) {
+		return &RemovedLeafError{Index: proof.leafIndex}
+	}
+	calculated := calculateSortedPairRootHash(proof, SortedPair.nodeHasher(hasher))
+	if !bytes.Equal(calculated, root) {
+		return &RootMismatchError{Computed: calculated, Expected: root}
 	}
 	return nil
 }
 
+// treeDepth returns the number of levels above the leaves in a tree with leafCount leaves.
+// A single leaf is still paired with itself, so the depth is never less than one.
+func treeDepth(leafCount int) int {
+	if leafCount <= 2 {
+		return 1
+	}
+	return bits.Len(uint(leafCount - 1))
+}
+
 // GenerateProof creates a proof for the leaf at the provided index.
-// It returns an error if the index is out of bounds.
+// It returns an error if the index is out of bounds or the leaf was removed.
 func (mt *MerkleTree) GenerateProof(idx int) (*Proof, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	return mt.generateProof(idx)
+}
+
+func (mt *MerkleTree) generateProof(idx int) (*Proof, error) {
 	if idx < 0 || idx >= len(mt.leaves) {
-		return nil, leafIndexOutOfBound
+		return nil, &IndexOutOfRangeError{Index: idx, Size: len(mt.leaves)}
+	}
+	if mt.leaves[idx].isRemoved() {
+		return nil, &RemovedLeafError{Index: idx}
 	}
 	siblingHashes := collectSiblingsHashes(mt.root, nil, make([][]byte, 0, len(mt.leaves)/2), len(mt.leaves), idx)
 	return NewProof(idx, mt.leaves[idx].Hash(), siblingHashes), nil
@@ -59,7 +208,9 @@ func (mt *MerkleTree) GenerateProof(idx int) (*Proof, error) {
 func collectSiblingsHashes(n, sibling node, siblingHashes [][]byte, remainingLen, tmpIdx int) [][]byte {
 	switch n.(type) {
 	case *Leaf:
-		siblingHashes = append(siblingHashes, sibling.Hash())
+		if sibling != nil {
+			siblingHashes = append(siblingHashes, sibling.Hash())
+		}
 	case *nonLeaf:
 		powerOf2 := nearestSmallerPowerOf2(remainingLen)
 		left := n.(*nonLeaf).left
@@ -83,6 +234,46 @@ func collectSiblingsHashes(n, sibling node, siblingHashes [][]byte, remainingLen
 	return siblingHashes
 }
 
+// subtree returns the root of the complete subtree of 2^height leaves starting at the leaf with index lo.
+// The subtree has to be fully contained in the tree.
+func (mt *MerkleTree) subtree(lo, height int) node {
+	n, _ := mt.descend(lo, height, nil)
+	return n
+}
+
+// descend walks from the root to the complete subtree of 2^height leaves starting at the leaf with index lo.
+// It returns the root of the subtree and appends the inner nodes passed on the way to path.
+func (mt *MerkleTree) descend(lo, height int, path []*nonLeaf) (node, []*nonLeaf) {
+	n, nLo, size := mt.root, 0, len(mt.leaves)
+	for {
+		nl, ok := n.(*nonLeaf)
+		if !ok {
+			return n, path
+		}
+		if isUnpaired(nl, size) {
+			path = append(path, nl)
+			n = nl.left
+			continue
+		}
+		if nLo == lo && size == 1<<height {
+			return n, path
+		}
+		path = append(path, nl)
+		powerOf2 := nearestSmallerPowerOf2(size)
+		if lo < nLo+powerOf2 {
+			n, size = nl.left, powerOf2
+		} else {
+			n, nLo, size = nl.right, nLo+powerOf2, size-powerOf2
+		}
+	}
+}
+
+// isUnpaired reports whether the right child of a node covering size leaves only repeats its left child, see buildRoot.
+func isUnpaired(nl *nonLeaf, size int) bool {
+	_, ok := nl.right.(*nonLeaf)
+	return size == 1 || (ok && !nl.right.hasChildren())
+}
+
 func nearestSmallerPowerOf2(n int) int {
 	if n < 1 {
 		return 0
@@ -99,30 +290,224 @@ func nearestSmallerPowerOf2(n int) int {
 
 // Hash returns the root hash of the Merkle tree.
 func (mt *MerkleTree) Hash() []byte {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
 	return mt.root.Hash()
 }
 
+// Len returns the number of leaves in the Merkle tree.
+func (mt *MerkleTree) Len() int {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	return len(mt.leaves)
+}
+
+// SubtreeHash returns the hash of the subtree of 2^height leaves starting at the leaf with index lo, which has
+// to be a multiple of 2^height. If the range extends beyond the last leaf, the hash covers the leaves within the tree,
+// calculated as if the subtree was extended to the full size like in a larger tree. Trees with the same leaves
+// and options have the same subtree hashes, so comparing them reveals which ranges of leaves differ.
+// It returns an error if lo is out of bounds or not aligned.
+func (mt *MerkleTree) SubtreeHash(lo, height int) ([]byte, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	if lo < 0 || lo >= len(mt.leaves) {
+		return nil, &IndexOutOfRangeError{Index: lo, Size: len(mt.leaves)}
+	}
+	if height < 0 || height >= bits.UintSize-1 || lo&(1<<height-1) != 0 {
+		return nil, ErrUnalignedRange
+	}
+	return mt.subtreeHash(lo, height, len(mt.leaves)), nil
+}
+
 // Append adds new leaves to the Merkle tree.
-// The root of the tree is recalculated after appending the leaves.
+// The root of the tree is recalculated after appending the leaves. Only the nodes on the right edge
+// of the tree are rebuilt, so appending a leaf costs O(log n) hashes.
 func (mt *MerkleTree) Append(leaves ...*Leaf) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if len(leaves) == 0 {
+		return
+	}
+	leafHasher := mt.opts.mode.leafHasher(mt.hasher)
+	nodeHasher := mt.opts.mode.nodeHasher(mt.hasher)
 	for _, leaf := range leaves {
-		leaf.hashFunc = mt.hasher
+		leaf.hashFunc = leafHasher
+		leaf.storedID = 0
+		// every trailing one in the binary representation of the number of leaves
+		// stands for a complete subtree which is now merged with a new one of the same size
+		mt.frontier = append(mt.frontier, leaf)
+		for size := len(mt.leaves); size&1 == 1; size >>= 1 {
+			last := len(mt.frontier) - 1
+			mt.frontier = append(mt.frontier[:last-1], newNonLeaf(mt.frontier[last-1], mt.frontier[last], nodeHasher))
+		}
+		mt.leaves = append(mt.leaves, leaf)
+	}
+	mt.root = mt.rootFromFrontier()
+	mt.root.Hash()
+	for idx := len(mt.leaves) - len(leaves); idx < len(mt.leaves); idx++ {
+		mt.indexLeaf(idx)
+	}
+}
+
+// collectFrontier returns the roots of the complete subtrees on the right edge of the tree.
+func (mt *MerkleTree) collectFrontier() []node {
+	return mt.frontierOf(len(mt.leaves))
+}
+
+// frontierOf returns the roots of the complete subtrees on the right edge of the first size leaves of the tree.
+// Every complete subtree of the first leaves is a subtree of the whole tree as well.
+func (mt *MerkleTree) frontierOf(size int) []node {
+	frontier := make([]node, 0, bits.OnesCount(uint(size)))
+	lo := 0
+	for height := bits.Len(uint(size)) - 1; height >= 0; height-- {
+		if (size>>height)&1 == 1 {
+			frontier = append(frontier, mt.subtree(lo, height))
+			lo += 1 << height
+		}
 	}
-	mt.leaves = append(mt.leaves, leaves...)
-	mt.root = buildRoot(mt.leaves, mt.hasher)
+	return frontier
+}
+
+// rootFromFrontier joins the complete subtrees of the frontier into the root of the tree,
+// producing the same structure as buildRoot.
+func (mt *MerkleTree) rootFromFrontier() node {
+	nodeHasher := mt.opts.mode.nodeHasher(mt.hasher)
+	promoteOdd := mt.opts.mode.promotesOdd()
+	size := len(mt.leaves)
+	next := len(mt.frontier) - 1
+	var root node
+	rootHeight := 0
+	for height := 0; size>>height > 0; height++ {
+		if (size>>height)&1 == 0 {
+			continue
+		}
+		peak := mt.frontier[next]
+		next--
+		if root == nil {
+			root, rootHeight = peak, height
+			continue
+		}
+		for ; !promoteOdd && rootHeight < height; rootHeight++ {
+			root = newUnpairedNonLeaf(root, nodeHasher)
+		}
+		root, rootHeight = newNonLeaf(peak, root, nodeHasher), height+1
+	}
+	if !root.hasChildren() && !promoteOdd {
+		root = newUnpairedNonLeaf(root, nodeHasher)
+	}
+	return root
+}
+
+// Update replaces the content of the leaf at the provided index. The leaf is modified in place
+// and only the hashes of the nodes on the path from the leaf to the root are recalculated.
+// It returns an error if the index is out of bounds.
+func (mt *MerkleTree) Update(idx int, content []byte) error {
+	return mt.UpdateMany(map[int][]byte{idx: content})
+}
+
+// UpdateMany replaces the contents of the leaves at the provided indices. Paths shared by several
+// leaves are recalculated only once. It returns an error without modifying the tree if any index is out of bounds.
+func (mt *MerkleTree) UpdateMany(contents map[int][]byte) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	for idx := range contents {
+		if idx < 0 || idx >= len(mt.leaves) {
+			return &IndexOutOfRangeError{Index: idx, Size: len(mt.leaves)}
+		}
+	}
+	var path []*nonLeaf
+	for idx, content := range contents {
+		mt.unindexLeaf(idx)
+		leaf := mt.leaves[idx]
+		leaf.content = content
+		leaf.cachedHash = nil
+		leaf.storedID = 0
+		_, path = mt.descend(idx, 0, path[:0])
+		for _, nl := range path {
+			nl.resetHash()
+		}
+	}
+	mt.root.Hash()
+	for idx := range contents {
+		mt.indexLeaf(idx)
+	}
+	return nil
+}
+
+// Remove removes the leaf at the provided index, replacing it with a tombstone whose hash is a sequence of zero bytes
+// as long as the hash of the leaf. The indices of the other leaves don't change and only the hashes of the nodes
+// on the path from the leaf to the root are recalculated. Proofs can't be generated for the removed leaf and proofs
+// with a tombstone as the leaf hash are rejected, while the proofs generated before for its index don't match
+// the new root. Update puts a new leaf at the index. The tree is no longer consistent with its previous versions.
+// It returns an error if the index is out of bounds or the leaf was already removed.
+func (mt *MerkleTree) Remove(idx int) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if idx < 0 || idx >= len(mt.leaves) {
+		return &IndexOutOfRangeError{Index: idx, Size: len(mt.leaves)}
+	}
+	leaf := mt.leaves[idx]
+	if leaf.isRemoved() {
+		return &RemovedLeafError{Index: idx}
+	}
+	mt.unindexLeaf(idx)
+	leaf.content = nil
+	leaf.cachedHash = make([]byte, len(leaf.Hash()))
+	leaf.storedID = 0
+	_, path := mt.descend(idx, 0, nil)
+	for _, nl := range path {
+		nl.resetHash()
+	}
+	mt.root.Hash()
+	return nil
+}
+
+// Truncate removes all the leaves from the index n on, keeping the first n leaves. The subtrees of the kept leaves
+// are reused and only the nodes on the new right edge of the tree are created, so the tree is the same as one built
+// from the first n leaves. The truncated tree is consistent with its previous versions of up to n leaves.
+// It returns ErrEmptyTree if n isn't positive and an error if n is larger than the number of leaves.
+func (mt *MerkleTree) Truncate(n int) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if n <= 0 {
+		return ErrEmptyTree
+	}
+	if n > len(mt.leaves) {
+		return &IndexOutOfRangeError{Index: n, Size: len(mt.leaves)}
+	}
+	if n == len(mt.leaves) {
+		return nil
+	}
+	mt.frontier = mt.frontierOf(n)
+	// the truncated leaves are released, as the slice keeps its capacity for appending
+	truncated := mt.leaves[n:]
+	for i := range truncated {
+		mt.unindexLeaf(n + i)
+		truncated[i] = nil
+	}
+	mt.leaves = mt.leaves[:n]
+	mt.root = mt.rootFromFrontier()
+	mt.root.Hash()
+	return nil
 }
 
 // String returns a string representation of the Merkle tree.
 func (mt *MerkleTree) String() string {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
 	return regexp.MustCompile("\n\n+").ReplaceAllString(mt.root.getString(""), "\n")
 }
 
-func buildRoot[T node](nodes []T, hasher Hasher) node {
+func buildRoot[T node](nodes []T, hasher sumHasher, o options) node {
+	return buildLevel(nodes, o.mode.nodeHasher(hasher), o.mode.promotesOdd(), o.workers)
+}
+
+func buildLevel[T node](nodes []T, hasher pairHasher, promoteOdd bool, workers int) node {
 	if len(nodes) == 1 {
-		if nodes[0].hasChildren() {
+		if nodes[0].hasChildren() || promoteOdd {
 			return nodes[0]
 		} else {
-			return newNonLeaf(nodes[0], nodes[0], hasher)
+			return newUnpairedNonLeaf(nodes[0], hasher)
 		}
 	}
 	parents := make([]node, 0, (len(nodes)/2)+1)
@@ -140,14 +525,41 @@ func buildRoot[T node](nodes []T, hasher Hasher) node {
 			right = nil
 		}
 	}
-	if left != nil && right == nil {
-		var r node
-		if left.hasChildren() {
-			r = &nonLeaf{cachedHash: left.Hash()}
-		} else {
-			r = left
+	if left != nil && promoteOdd {
+		parents = append(parents, left)
+	} else if left != nil && right == nil {
+		parents = append(parents, newUnpairedNonLeaf(left, hasher))
+	}
+	hashNodes(parents, workers)
+	return buildLevel(parents, hasher, promoteOdd, workers)
+}
+
+// minParallelChunk is the smallest number of nodes hashed by a single worker.
+const minParallelChunk = 1024
+
+// hashNodes calculates the hashes of the nodes, which are then cached, splitting the work between the workers.
+// The children of the nodes have to be hashed already. With less than two workers the hashes are calculated lazily.
+func hashNodes[T node](nodes []T, workers int) {
+	if workers < 2 {
+		return
+	}
+	chunk := (len(nodes) + workers - 1) / workers
+	if chunk < minParallelChunk {
+		chunk = minParallelChunk
+	}
+	var wg sync.WaitGroup
+	for lo := 0; lo < len(nodes); lo += chunk {
+		hi := lo + chunk
+		if hi > len(nodes) {
+			hi = len(nodes)
 		}
-		parents = append(parents, newNonLeaf(left, r, hasher))
+		wg.Add(1)
+		go func(nodes []T) {
+			defer wg.Done()
+			for _, n := range nodes {
+				n.Hash()
+			}
+		}(nodes[lo:hi])
 	}
-	return buildRoot(parents, hasher)
+	wg.Wait()
 }
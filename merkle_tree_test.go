@@ -1,14 +1,26 @@
 package merkletree
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"runtime"
+	"sort"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// testModes holds every Mode, for the tests which run for all of them.
+var testModes = map[string]Mode{
+	"duplicate odd": DuplicateOdd,
+	"rfc6962":       RFC6962,
+	"sorted pair":   SortedPair,
+}
+
 func TestMerkleTree_Hash(t *testing.T) {
 	nodes := []*Leaf{
 		NewLeaf([]byte("one")),
@@ -99,7 +111,7 @@ func TestMerkleTree_VerifyProof(t *testing.T) {
 					sibling3,
 				})
 			},
-			leafHashMismatch,
+			ErrLeafHashMismatch,
 		},
 		{
 			"wrong proof",
@@ -118,20 +130,20 @@ func TestMerkleTree_VerifyProof(t *testing.T) {
 					sibling3,
 				})
 			},
-			wrongProof,
+			ErrWrongProof,
 		},
 		{
 			"leaf index out of bound",
 			func() *Proof {
 				return NewProof(100, []byte{}, [][]byte{})
 			},
-			leafIndexOutOfBound,
+			ErrLeafIndexOutOfBound,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			if tc.err != nil {
-				assert.EqualError(t, merkleTree.VerifyProof(tc.proof()), tc.err.Error())
+				assert.ErrorIs(t, merkleTree.VerifyProof(tc.proof()), tc.err)
 			} else {
 				assert.NoError(t, merkleTree.VerifyProof(tc.proof()))
 			}
@@ -264,7 +276,7 @@ func TestMerkleTree_GenerateProof(t *testing.T) {
 			func() *Proof {
 				return nil
 			},
-			leafIndexOutOfBound,
+			ErrLeafIndexOutOfBound,
 		},
 	}
 	for _, tc := range testCases {
@@ -272,7 +284,7 @@ func TestMerkleTree_GenerateProof(t *testing.T) {
 			tree := tc.tree()
 			proof, err := tree.GenerateProof(tc.idx)
 			if tc.err != nil {
-				assert.EqualError(t, err, tc.err.Error())
+				assert.ErrorIs(t, err, tc.err)
 			} else {
 				assert.True(t, proof.Equal(tc.proof()))
 				assert.NoError(t, tree.VerifyProof(proof))
@@ -309,6 +321,89 @@ func TestMerkleTree_GenerateProof_Iterations(t *testing.T) {
 	}
 }
 
+func TestVerifyProof(t *testing.T) {
+	for i := 1; i < 70; i++ {
+		leaves := make([]*Leaf, 0, i)
+		for j := 0; j < i; j++ {
+			leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+		}
+		tree, err := NewMerkleTree(leaves, SHA256Hasher)
+		require.NoError(t, err)
+		for j := 0; j < i; j++ {
+			proof, err := tree.GenerateProof(j)
+			require.NoError(t, err)
+			assert.NoError(t, VerifyProof(proof, tree.Hash(), SHA256Hasher, i), fmt.Sprintf("for %d leaves and index=%d", i, j))
+		}
+	}
+}
+
+func TestVerifyProof_Errors(t *testing.T) {
+	tree, err := NewMerkleTree([]*Leaf{
+		NewLeaf([]byte("one")),
+		NewLeaf([]byte("two")),
+		NewLeaf([]byte("three")),
+		NewLeaf([]byte("four")),
+		NewLeaf([]byte("five")),
+	}, SHA256Hasher)
+	require.NoError(t, err)
+	proof, err := tree.GenerateProof(4)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		proof     *Proof
+		leafCount int
+		err       error
+	}{
+		{
+			"valid proof",
+			proof,
+			5,
+			nil,
+		},
+		{
+			"leaf index out of bound",
+			proof,
+			4,
+			ErrLeafIndexOutOfBound,
+		},
+		{
+			"negative leaf index",
+			NewProof(-1, proof.leafHash, proof.siblingHashes),
+			5,
+			ErrLeafIndexOutOfBound,
+		},
+		{
+			"wrong leaf hash",
+			NewProof(4, SHA256Hasher([]byte("six")), proof.siblingHashes),
+			5,
+			ErrWrongProof,
+		},
+		{
+			"missing sibling",
+			NewProof(4, proof.leafHash, proof.siblingHashes[:2]),
+			5,
+			ErrWrongProof,
+		},
+		{
+			"duplicated sibling replaced",
+			NewProof(4, proof.leafHash, [][]byte{SHA256Hasher([]byte("six")), proof.siblingHashes[1], proof.siblingHashes[2]}),
+			5,
+			ErrWrongProof,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyProof(tc.proof, tree.Hash(), SHA256Hasher, tc.leafCount)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestMerkleTree_Append(t *testing.T) {
 	tree, err := NewMerkleTree([]*Leaf{
 		NewLeaf([]byte("one")),
@@ -326,6 +421,295 @@ func TestMerkleTree_Append(t *testing.T) {
 	assert.Equal(t, 7, len(tree.leaves))
 }
 
+func TestMerkleTree_Append_Incremental(t *testing.T) {
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			tree, err := NewMerkleTree([]*Leaf{NewLeaf([]byte("0"))}, SHA256Hasher, WithMode(mode))
+			require.NoError(t, err)
+			for i := 1; i < 70; i++ {
+				if i%5 == 0 {
+					tree.Append(NewLeaf([]byte(fmt.Sprintf("%d", i))), NewLeaf([]byte(fmt.Sprintf("%d", i+100))))
+				} else {
+					tree.Append(NewLeaf([]byte(fmt.Sprintf("%d", i))))
+				}
+				leaves := make([]*Leaf, 0, len(tree.leaves))
+				for _, l := range tree.leaves {
+					leaves = append(leaves, NewLeaf(l.content))
+				}
+				expected, err := NewMerkleTree(leaves, SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				require.Equal(t, expected.Hash(), tree.Hash(), fmt.Sprintf("for %d leaves", len(leaves)))
+				require.Equal(t, expected.String(), tree.String(), fmt.Sprintf("for %d leaves", len(leaves)))
+				for j := range leaves {
+					proof, err := tree.GenerateProof(j)
+					require.NoError(t, err)
+					expectedProof, err := expected.GenerateProof(j)
+					require.NoError(t, err)
+					assert.True(t, expectedProof.Equal(proof), fmt.Sprintf("for %d leaves and index=%d", len(leaves), j))
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_Update(t *testing.T) {
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			for i := 1; i < 25; i++ {
+				leaves := make([]*Leaf, 0, i)
+				contents := make([][]byte, 0, i)
+				for j := 0; j < i; j++ {
+					leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+					contents = append(contents, []byte(fmt.Sprintf("%d", j)))
+				}
+				tree, err := NewMerkleTree(leaves, SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				for j := 0; j < i; j++ {
+					contents[j] = []byte(fmt.Sprintf("updated %d", j))
+					require.NoError(t, tree.Update(j, contents[j]))
+
+					expectedLeaves := make([]*Leaf, 0, i)
+					for _, c := range contents {
+						expectedLeaves = append(expectedLeaves, NewLeaf(c))
+					}
+					expected, err := NewMerkleTree(expectedLeaves, SHA256Hasher, WithMode(mode))
+					require.NoError(t, err)
+					require.Equal(t, expected.Hash(), tree.Hash(), fmt.Sprintf("for %d leaves and index=%d", i, j))
+					require.Equal(t, expected.String(), tree.String(), fmt.Sprintf("for %d leaves and index=%d", i, j))
+					for k := 0; k < i; k++ {
+						proof, err := tree.GenerateProof(k)
+						require.NoError(t, err)
+						assert.NoError(t, tree.VerifyProof(proof), fmt.Sprintf("for %d leaves, index=%d and proof=%d", i, j, k))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_UpdateMany(t *testing.T) {
+	leaves := make([]*Leaf, 0, 13)
+	expectedLeaves := make([]*Leaf, 0, 13)
+	for j := 0; j < 13; j++ {
+		leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+		if j%3 == 0 {
+			expectedLeaves = append(expectedLeaves, NewLeaf([]byte(fmt.Sprintf("updated %d", j))))
+		} else {
+			expectedLeaves = append(expectedLeaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+		}
+	}
+	tree, err := NewMerkleTree(leaves, SHA256Hasher)
+	require.NoError(t, err)
+	oldProof, err := tree.GenerateProof(3)
+	require.NoError(t, err)
+
+	require.NoError(t, tree.UpdateMany(map[int][]byte{
+		0:  []byte("updated 0"),
+		3:  []byte("updated 3"),
+		6:  []byte("updated 6"),
+		9:  []byte("updated 9"),
+		12: []byte("updated 12"),
+	}))
+
+	expected, err := NewMerkleTree(expectedLeaves, SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Hash(), tree.Hash())
+	assert.ErrorIs(t, tree.VerifyProof(oldProof), ErrLeafHashMismatch)
+	for j := 0; j < 13; j++ {
+		proof, err := tree.GenerateProof(j)
+		require.NoError(t, err)
+		assert.NoError(t, VerifyProof(proof, expected.Hash(), SHA256Hasher, 13))
+	}
+}
+
+func TestMerkleTree_UpdateMany_OutOfBound(t *testing.T) {
+	tree, err := NewMerkleTree([]*Leaf{
+		NewLeaf([]byte("one")),
+		NewLeaf([]byte("two")),
+		NewLeaf([]byte("three")),
+	}, SHA256Hasher)
+	require.NoError(t, err)
+	hash := tree.Hash()
+
+	assert.ErrorIs(t, tree.UpdateMany(map[int][]byte{0: []byte("updated"), 3: []byte("updated")}), ErrLeafIndexOutOfBound)
+	assert.ErrorIs(t, tree.Update(-1, []byte("updated")), ErrLeafIndexOutOfBound)
+	assert.Equal(t, hash, tree.Hash())
+}
+
+func TestMerkleTree_Remove(t *testing.T) {
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			for i := 1; i < 20; i++ {
+				contents := make([][]byte, i)
+				for j := range contents {
+					contents[j] = []byte(fmt.Sprintf("%d", j))
+				}
+				tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				removed := map[int]bool{}
+				for j := 0; j < i; j += 3 {
+					oldProof, err := tree.GenerateProof(j)
+					require.NoError(t, err)
+					oldHash := tree.Hash()
+					require.NoError(t, tree.Remove(j))
+					removed[j] = true
+
+					// the removed leaves are tombstones hashing to zero bytes
+					expectedLeaves := make([]*Leaf, i)
+					for k, content := range contents {
+						expectedLeaves[k] = NewLeaf(content)
+						if removed[k] {
+							expectedLeaves[k] = newHashLeaf(make([]byte, 32))
+						}
+					}
+					expected, err := NewMerkleTree(expectedLeaves, SHA256Hasher, WithMode(mode))
+					require.NoError(t, err)
+					require.Equal(t, expected.Hash(), tree.Hash(), fmt.Sprintf("for %d leaves and index=%d", i, j))
+					assert.Equal(t, i, tree.Len())
+
+					_, err = tree.GenerateProof(j)
+					assert.ErrorIs(t, err, ErrLeafRemoved)
+					assert.ErrorIs(t, tree.VerifyProof(oldProof), ErrLeafRemoved)
+					assert.Error(t, VerifyProof(oldProof, tree.Hash(), SHA256Hasher, i, WithMode(mode)))
+					assert.NoError(t, VerifyProof(oldProof, oldHash, SHA256Hasher, i, WithMode(mode)))
+					for k := 0; k < i; k++ {
+						if removed[k] {
+							continue
+						}
+						proof, err := tree.GenerateProof(k)
+						require.NoError(t, err)
+						assert.NoError(t, VerifyProof(proof, tree.Hash(), SHA256Hasher, i, WithMode(mode)),
+							fmt.Sprintf("for %d leaves, index=%d and proof=%d", i, j, k))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_Remove_Errors(t *testing.T) {
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	assert.ErrorIs(t, tree.Remove(3), ErrLeafIndexOutOfBound)
+	assert.ErrorIs(t, tree.Remove(-1), ErrLeafIndexOutOfBound)
+	proof, err := tree.GenerateProof(1)
+	require.NoError(t, err)
+	require.NoError(t, tree.Remove(1))
+	assert.ErrorIs(t, tree.Remove(1), ErrLeafRemoved)
+	var removedErr *RemovedLeafError
+	_, err = tree.GenerateMultiProof([]int{0, 1})
+	require.True(t, errors.As(err, &removedErr))
+	assert.Equal(t, 1, removedErr.Index)
+
+	// a proof of the tombstone itself is rejected
+	forged := NewProof(1, make([]byte, 32), proof.SiblingHashes())
+	assert.ErrorIs(t, VerifyProof(forged, tree.Hash(), SHA256Hasher, 3), ErrLeafRemoved)
+	sorted, err := NewMerkleTree(newLeaves([][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}), SHA256Hasher, WithMode(SortedPair))
+	require.NoError(t, err)
+	proof, err = sorted.GenerateProof(2)
+	require.NoError(t, err)
+	require.NoError(t, sorted.Remove(2))
+	forged = NewProof(2, make([]byte, 32), proof.SiblingHashes())
+	assert.ErrorIs(t, VerifySortedPairProof(forged, sorted.Hash(), SHA256Hasher), ErrLeafRemoved)
+
+	// saved and exported trees keep the tombstones
+	store := NewMemoryNodeStore()
+	require.NoError(t, tree.Save(store))
+	stored, err := OpenStoredTree(store)
+	require.NoError(t, err)
+	_, err = stored.GenerateProof(1)
+	assert.ErrorIs(t, err, ErrLeafRemoved)
+	var buf bytes.Buffer
+	_, err = tree.WriteTo(&buf)
+	require.NoError(t, err)
+	read, err := ReadMerkleTree(&buf, SHA256Hasher)
+	require.NoError(t, err)
+	_, err = read.GenerateProof(1)
+	assert.ErrorIs(t, err, ErrLeafRemoved)
+
+	// a removed leaf is replaced by updating it
+	require.NoError(t, tree.Update(1, []byte("two")))
+	proof, err = tree.GenerateProof(1)
+	require.NoError(t, err)
+	assert.NoError(t, tree.VerifyProof(proof))
+}
+
+func TestMerkleTree_Truncate(t *testing.T) {
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			for i := 1; i < 20; i++ {
+				contents := make([][]byte, i)
+				for j := range contents {
+					contents[j] = []byte(fmt.Sprintf("%d", j))
+				}
+				for n := 1; n <= i; n++ {
+					tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+					require.NoError(t, err)
+					oldProof, err := tree.GenerateProof(i - 1)
+					require.NoError(t, err)
+					require.NoError(t, tree.Truncate(n))
+
+					expected, err := NewMerkleTree(newLeaves(contents[:n]), SHA256Hasher, WithMode(mode))
+					require.NoError(t, err)
+					require.Equal(t, expected.Hash(), tree.Hash(), fmt.Sprintf("for %d leaves truncated to %d", i, n))
+					require.Equal(t, expected.String(), tree.String())
+					assert.Equal(t, n, tree.Len())
+					if n < i {
+						assert.ErrorIs(t, tree.VerifyProof(oldProof), ErrLeafIndexOutOfBound)
+						_, err = tree.GenerateProof(n)
+						assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+					}
+					for k := 0; k < n; k++ {
+						proof, err := tree.GenerateProof(k)
+						require.NoError(t, err)
+						assert.NoError(t, tree.VerifyProof(proof))
+					}
+
+					// the truncated tree grows like any other one
+					tree.Append(NewLeaf([]byte("appended")))
+					expected.Append(NewLeaf([]byte("appended")))
+					assert.Equal(t, expected.Hash(), tree.Hash())
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_Truncate_Errors(t *testing.T) {
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	hash := tree.Hash()
+	assert.ErrorIs(t, tree.Truncate(0), ErrEmptyTree)
+	assert.ErrorIs(t, tree.Truncate(4), ErrLeafIndexOutOfBound)
+	assert.NoError(t, tree.Truncate(3))
+	assert.Equal(t, hash, tree.Hash())
+}
+
+func TestNewMerkleTree_WithWorkers(t *testing.T) {
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			for _, size := range []int{1, 2, 3, 1023, 1024, 1025, 4097, 10_000} {
+				serialLeaves := make([]*Leaf, 0, size)
+				parallelLeaves := make([]*Leaf, 0, size)
+				for j := 0; j < size; j++ {
+					serialLeaves = append(serialLeaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+					parallelLeaves = append(parallelLeaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+				}
+				serial, err := NewMerkleTree(serialLeaves, SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				parallel, err := NewMerkleTree(parallelLeaves, SHA256Hasher, WithMode(mode), WithWorkers(8))
+				require.NoError(t, err)
+				assert.Equal(t, serial.Hash(), parallel.Hash(), fmt.Sprintf("for %d leaves", size))
+				for _, idx := range []int{0, size / 2, size - 1} {
+					proof, err := parallel.GenerateProof(idx)
+					require.NoError(t, err)
+					assert.NoError(t, serial.VerifyProof(proof), fmt.Sprintf("for %d leaves and index=%d", size, idx))
+				}
+			}
+		})
+	}
+}
+
 func TestMerkleTree_String(t *testing.T) {
 	tree, err := NewMerkleTree([]*Leaf{
 		NewLeaf([]byte("one")),
@@ -347,3 +731,418 @@ const treeString = `(hash: d22fcd08bc1dfb62432f9cb6c99e70491fb5aa6c56bfb7ed99933
                                                                                                                                                      |                                                                           |r (content: five, hash: 222b0bd51fcef7e65c2e62db2ed65457013bab56be6fafeb19ee11d453153c80)
                                                                                                                                                      |r (hash: 1560f46a8f24c5a167580b38afe45fdec3be6f8aee90c1373f5853d8e06c7b17)
 `
+
+// rfc6962Leaves are the leaves of the test vectors published with the Certificate Transparency implementations.
+var rfc6962Leaves = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+func newRFC6962Tree(t *testing.T, size int) *MerkleTree {
+	leaves := make([]*Leaf, 0, size)
+	for _, l := range rfc6962Leaves[:size] {
+		content, err := hex.DecodeString(l)
+		require.NoError(t, err)
+		leaves = append(leaves, NewLeaf(content))
+	}
+	tree, err := NewMerkleTree(leaves, SHA256Hasher, WithMode(RFC6962))
+	require.NoError(t, err)
+	return tree
+}
+
+func TestMerkleTree_Hash_RFC6962(t *testing.T) {
+	roots := []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+	for i, root := range roots {
+		t.Run(fmt.Sprintf("%d leaves", i+1), func(t *testing.T) {
+			assert.Equal(t, root, hex.EncodeToString(newRFC6962Tree(t, i+1).Hash()))
+		})
+	}
+}
+
+func TestMerkleTree_GenerateProof_RFC6962(t *testing.T) {
+	testCases := []struct {
+		idx      int
+		size     int
+		siblings []string
+	}{
+		{0, 1, []string{}},
+		{0, 8, []string{
+			"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+		}},
+		{5, 8, []string{
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+			"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+			"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		}},
+		{2, 3, []string{
+			"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		}},
+		{1, 5, []string{
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+			"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+			"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("leaf %d of %d", tc.idx, tc.size), func(t *testing.T) {
+			tree := newRFC6962Tree(t, tc.size)
+			proof, err := tree.GenerateProof(tc.idx)
+			require.NoError(t, err)
+			siblings := make([]string, 0, len(proof.siblingHashes))
+			for _, s := range proof.siblingHashes {
+				siblings = append(siblings, hex.EncodeToString(s))
+			}
+			assert.Equal(t, tc.siblings, siblings)
+			assert.NoError(t, tree.VerifyProof(proof))
+			assert.NoError(t, VerifyProof(proof, tree.Hash(), SHA256Hasher, tc.size, WithMode(RFC6962)))
+			assert.ErrorIs(t, VerifyProof(proof, tree.Hash(), SHA256Hasher, tc.size), ErrWrongProof)
+		})
+	}
+}
+
+func TestMerkleTree_GenerateProof_Iterations_RFC6962(t *testing.T) {
+	for i := 1; i < 100; i++ {
+		leaves := make([]*Leaf, 0, i)
+		for j := 0; j < i; j++ {
+			leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+		}
+		tree, err := NewMerkleTree(leaves, SHA256Hasher, WithMode(RFC6962))
+		require.NoError(t, err)
+		for j := 0; j < i; j++ {
+			proof, err := tree.GenerateProof(j)
+			require.NoError(t, err)
+			assert.NoError(t, tree.VerifyProof(proof), fmt.Sprintf("for %d leaves and index=%d", i, j))
+			assert.NoError(t, VerifyProof(proof, tree.Hash(), SHA256Hasher, i, WithMode(RFC6962)), fmt.Sprintf("for %d leaves and index=%d", i, j))
+			if j > 0 {
+				assert.Error(t, VerifyProof(NewProof(j-1, proof.leafHash, proof.siblingHashes), tree.Hash(), SHA256Hasher, i, WithMode(RFC6962)))
+			}
+		}
+		tree.Append(NewLeaf([]byte(fmt.Sprintf("%d", i))))
+		proof, err := tree.GenerateProof(i)
+		require.NoError(t, err)
+		assert.NoError(t, tree.VerifyProof(proof))
+	}
+}
+
+func TestMerkleTree_RFC6962_SecondPreimage(t *testing.T) {
+	tree := newRFC6962Tree(t, 4)
+	inner := tree.root.(*nonLeaf).left
+	forged, err := NewMerkleTree([]*Leaf{
+		NewLeaf(append(append([]byte{}, inner.(*nonLeaf).left.Hash()...), inner.(*nonLeaf).right.Hash()...)),
+		NewLeaf(append(append([]byte{}, tree.root.(*nonLeaf).right.(*nonLeaf).left.Hash()...), tree.root.(*nonLeaf).right.(*nonLeaf).right.Hash()...)),
+	}, SHA256Hasher, WithMode(RFC6962))
+	require.NoError(t, err)
+	assert.NotEqual(t, tree.Hash(), forged.Hash())
+}
+
+func BenchmarkMerkleTree_Append(b *testing.B) {
+	for _, size := range []int{100, 1_000, 3_000} {
+		b.Run(fmt.Sprintf("incremental %d leaves", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree, err := NewMerkleTree([]*Leaf{NewLeaf([]byte("0"))}, SHA256Hasher)
+				require.NoError(b, err)
+				for j := 1; j < size; j++ {
+					tree.Append(NewLeaf([]byte(fmt.Sprintf("%d", j))))
+					tree.Hash()
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("rebuild %d leaves", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree, err := NewMerkleTree([]*Leaf{NewLeaf([]byte("0"))}, SHA256Hasher)
+				require.NoError(b, err)
+				for j := 1; j < size; j++ {
+					leaf := NewLeaf([]byte(fmt.Sprintf("%d", j)))
+					leaf.hashFunc = SHA256Hasher
+					tree.leaves = append(tree.leaves, leaf)
+					tree.root = buildRoot(tree.leaves, tree.hasher, tree.opts)
+					tree.Hash()
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_StructuredErrors(t *testing.T) {
+	tree, err := NewMerkleTree([]*Leaf{
+		NewLeaf([]byte("one")),
+		NewLeaf([]byte("two")),
+		NewLeaf([]byte("three")),
+	}, SHA256Hasher)
+	require.NoError(t, err)
+
+	_, err = NewMerkleTree(nil, SHA256Hasher)
+	assert.ErrorIs(t, err, ErrEmptyTree)
+
+	_, err = tree.GenerateProof(5)
+	var indexErr *IndexOutOfRangeError
+	require.ErrorAs(t, err, &indexErr)
+	assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+	assert.Equal(t, 5, indexErr.Index)
+	assert.Equal(t, 3, indexErr.Size)
+
+	proof, err := tree.GenerateProof(1)
+	require.NoError(t, err)
+
+	other := SHA256Hasher([]byte("other"))
+	err = tree.VerifyProof(NewProof(1, other, proof.siblingHashes))
+	var leafErr *LeafHashMismatchError
+	require.ErrorAs(t, err, &leafErr)
+	assert.ErrorIs(t, err, ErrLeafHashMismatch)
+	assert.Equal(t, 1, leafErr.Index)
+	assert.Equal(t, other, leafErr.Provided)
+	assert.Equal(t, tree.leaves[1].Hash(), leafErr.Expected)
+
+	err = VerifyProof(proof, other, SHA256Hasher, 3)
+	var rootErr *RootMismatchError
+	require.ErrorAs(t, err, &rootErr)
+	assert.ErrorIs(t, err, ErrWrongProof)
+	assert.Equal(t, tree.Hash(), rootErr.Computed)
+	assert.Equal(t, other, rootErr.Expected)
+
+	err = VerifyProof(NewProof(1, proof.leafHash, proof.siblingHashes[:1]), tree.Hash(), SHA256Hasher, 3)
+	assert.ErrorIs(t, err, ErrWrongProof)
+	assert.False(t, errors.As(err, &rootErr))
+}
+
+func TestMerkleTree_Concurrency(t *testing.T) {
+	leaves := make([]*Leaf, 0, 64)
+	for j := 0; j < 64; j++ {
+		leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+	}
+	tree, err := NewMerkleTree(leaves, SHA256Hasher)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for r := 0; r < 16; r++ {
+		readers.Add(1)
+		go func(r int) {
+			defer readers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				idx := (r*31 + i) % 64
+				proof, err := tree.GenerateProof(idx)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, tree.Hash())
+				// the tree may change between generating and verifying the proof
+				err = tree.VerifyProof(proof)
+				if err != nil && !errors.Is(err, ErrLeafHashMismatch) {
+					assert.ErrorIs(t, err, ErrWrongProof)
+				}
+				_, err = tree.GenerateMultiProof([]int{idx, 63 - idx})
+				assert.NoError(t, err)
+				_, err = tree.GenerateConsistencyProof(1+idx, 64)
+				assert.NoError(t, err)
+				if i%50 == 0 {
+					assert.NotEmpty(t, tree.String())
+				}
+			}
+		}(r)
+	}
+
+	for j := 64; j < 256; j++ {
+		tree.Append(NewLeaf([]byte(fmt.Sprintf("%d", j))))
+		if j%4 == 0 {
+			assert.NoError(t, tree.Update(j%64, []byte(fmt.Sprintf("updated %d", j))))
+		}
+	}
+	close(done)
+	readers.Wait()
+
+	for j := 0; j < 256; j++ {
+		proof, err := tree.GenerateProof(j)
+		require.NoError(t, err)
+		assert.NoError(t, tree.VerifyProof(proof))
+	}
+}
+
+func BenchmarkNewMerkleTree(b *testing.B) {
+	hashers := []struct {
+		name   string
+		hasher Hasher
+	}{
+		{"sha256", SHA256Hasher},
+		{"blake2b256", Blake2b256Hasher},
+	}
+	for _, h := range hashers {
+		for _, size := range []int{1_000, 100_000, 1_000_000, 10_000_000} {
+			for _, workers := range []int{1, runtime.NumCPU()} {
+				mode := "serial"
+				if workers > 1 {
+					mode = "parallel"
+				}
+				b.Run(fmt.Sprintf("%s %d leaves %s", h.name, size, mode), func(b *testing.B) {
+					leaves := make([]*Leaf, 0, size)
+					for j := 0; j < size; j++ {
+						leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+					}
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						b.StopTimer()
+						for _, l := range leaves {
+							l.cachedHash = nil
+						}
+						b.StartTimer()
+						_, err := NewMerkleTree(leaves, h.hasher, WithWorkers(workers))
+						require.NoError(b, err)
+					}
+				})
+			}
+		}
+	}
+}
+
+// standardMerkleTreeRoot calculates the root like OpenZeppelin StandardMerkleTree does,
+// placing the sorted leaf hashes at the end of a heap in reverse order.
+func standardMerkleTreeRoot(leafHashes [][]byte) []byte {
+	tree := make([][]byte, 2*len(leafHashes)-1)
+	for i, h := range leafHashes {
+		tree[len(tree)-1-i] = h
+	}
+	nodeHasher := SortedPair.nodeHasher(Hasher(Keccak256Hasher))
+	for i := len(tree) - 1 - len(leafHashes); i >= 0; i-- {
+		tree[i] = nodeHasher(tree[2*i+1], tree[2*i+2])
+	}
+	return tree[0]
+}
+
+func newSortedPairLeaves(contents [][]byte) []*Leaf {
+	leafHasher := SortedPair.leafHasher(Hasher(Keccak256Hasher))
+	sort.Slice(contents, func(i, j int) bool {
+		return bytes.Compare(leafHasher(contents[i]), leafHasher(contents[j])) < 0
+	})
+	leaves := make([]*Leaf, 0, len(contents))
+	for _, c := range contents {
+		leaves = append(leaves, NewLeaf(c))
+	}
+	return leaves
+}
+
+func TestMerkleTree_Hash_SortedPair(t *testing.T) {
+	// the example of the OpenZeppelin merkle-tree library with ABI-encoded (address, uint256) values
+	values := []string{
+		"0000000000000000000000001111111111111111111111111111111111111111" +
+			"0000000000000000000000000000000000000000000000004563918244f40000",
+		"0000000000000000000000002222222222222222222222222222222222222222" +
+			"00000000000000000000000000000000000000000000000022b1c8c1227a0000",
+	}
+	contents := make([][]byte, 0, len(values))
+	for _, v := range values {
+		content, err := hex.DecodeString(v)
+		require.NoError(t, err)
+		contents = append(contents, content)
+	}
+	tree, err := NewMerkleTree(newSortedPairLeaves(contents), Keccak256Hasher, WithMode(SortedPair))
+	require.NoError(t, err)
+	assert.Equal(t, "d4dee0beab2d53f2cc83e567171bd2820e49898130a22622b10ead383e90bd77", hex.EncodeToString(tree.Hash()))
+
+	for _, size := range []int{1, 2, 3, 4, 6, 8, 16} {
+		contents := make([][]byte, 0, size)
+		for j := 0; j < size; j++ {
+			contents = append(contents, []byte(fmt.Sprintf("%d", j)))
+		}
+		leaves := newSortedPairLeaves(contents)
+		tree, err := NewMerkleTree(leaves, Keccak256Hasher, WithMode(SortedPair))
+		require.NoError(t, err)
+		leafHashes := make([][]byte, 0, size)
+		for _, l := range leaves {
+			leafHashes = append(leafHashes, l.Hash())
+		}
+		assert.Equal(t, standardMerkleTreeRoot(leafHashes), tree.Hash(), fmt.Sprintf("for %d leaves", size))
+	}
+}
+
+func TestMerkleTree_GenerateProof_Iterations_SortedPair(t *testing.T) {
+	for i := 1; i < 50; i++ {
+		leaves := make([]*Leaf, 0, i)
+		for j := 0; j < i; j++ {
+			leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+		}
+		tree, err := NewMerkleTree(leaves, Keccak256Hasher, WithMode(SortedPair))
+		require.NoError(t, err)
+		for j := 0; j < i; j++ {
+			proof, err := tree.GenerateProof(j)
+			require.NoError(t, err)
+			msg := fmt.Sprintf("for %d leaves and index=%d", i, j)
+			assert.NoError(t, tree.VerifyProof(proof), msg)
+			assert.NoError(t, VerifyProof(proof, tree.Hash(), Keccak256Hasher, i, WithMode(SortedPair)), msg)
+			// the index isn't needed on-chain
+			assert.NoError(t, VerifySortedPairProof(NewProof(0, proof.leafHash, proof.siblingHashes), tree.Hash(), Keccak256Hasher), msg)
+			assert.ErrorIs(t, VerifySortedPairProof(NewProof(j, Keccak256Hasher([]byte("other")), proof.siblingHashes), tree.Hash(), Keccak256Hasher), ErrWrongProof, msg)
+		}
+	}
+}
+
+func TestMerkleTree_SubtreeHash(t *testing.T) {
+	for name, mode := range testModes {
+		for _, size := range []int{1, 2, 5, 8, 11} {
+			t.Run(fmt.Sprintf("%s %d leaves", name, size), func(t *testing.T) {
+				contents := make([][]byte, size)
+				for i := range contents {
+					contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+				}
+				tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				assert.Equal(t, size, tree.Len())
+
+				hash, err := tree.SubtreeHash(0, mode.treeHeight(size))
+				require.NoError(t, err)
+				assert.Equal(t, tree.Hash(), hash)
+				for i := range contents {
+					hash, err := tree.SubtreeHash(i, 0)
+					require.NoError(t, err)
+					assert.Equal(t, tree.leaves[i].Hash(), hash)
+				}
+				// a complete subtree, or the part of it within the tree if its odd nodes are promoted,
+				// has the root hash of a tree made of its leaves
+				for lo := 0; lo < size; lo += 4 {
+					hi := lo + 4
+					if hi > size {
+						if !mode.promotesOdd() {
+							continue
+						}
+						hi = size
+					}
+					subtree, err := NewMerkleTree(newLeaves(contents[lo:hi]), SHA256Hasher, WithMode(mode))
+					require.NoError(t, err)
+					hash, err := tree.SubtreeHash(lo, 2)
+					require.NoError(t, err)
+					assert.Equal(t, subtree.Hash(), hash)
+				}
+
+				_, err = tree.SubtreeHash(size, 0)
+				assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+				_, err = tree.SubtreeHash(-1, 0)
+				assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+				if size > 1 {
+					_, err = tree.SubtreeHash(1, 1)
+					assert.ErrorIs(t, err, ErrUnalignedRange)
+				}
+				_, err = tree.SubtreeHash(0, -1)
+				assert.ErrorIs(t, err, ErrUnalignedRange)
+			})
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package merkletree
+
+import (
+	"bytes"
+	"sort"
+)
+
+// MultiProof represents a proof of inclusion of several leaves in a Merkle tree.
+// It consists of the sorted indices of the leaves, their hashes and the hashes of the subtrees
+// which don't contain any of the leaves, so every hash is sent only once.
+type MultiProof struct {
+	leafIndices []int
+	leafHashes  [][]byte
+	hashes      [][]byte
+}
+
+// NewMultiProof creates a new instance of MultiProof given sorted leaf indices, the hashes of the leaves
+// and the auxiliary hashes in the order created by GenerateMultiProof.
+func NewMultiProof(leafIndices []int, leafHashes [][]byte, hashes [][]byte) *MultiProof {
+	return &MultiProof{leafIndices: leafIndices, leafHashes: leafHashes, hashes: hashes}
+}
+
+// LeafIndices returns the sorted indices of the leaves the proof was generated for.
+func (p *MultiProof) LeafIndices() []int {
+	return p.leafIndices
+}
+
+// LeafHashes returns the hashes of the leaves the proof was generated for, in the order of LeafIndices.
+func (p *MultiProof) LeafHashes() [][]byte {
+	return p.leafHashes
+}
+
+// Hashes returns the auxiliary hashes needed to rebuild the root hash from the leaves.
+func (p *MultiProof) Hashes() [][]byte {
+	return p.hashes
+}
+
+// GenerateMultiProof creates a single proof for the leaves at the provided indices.
+// Duplicated indices are ignored. It returns an error if no index is provided or any index is out of bounds
+// or refers to a removed leaf.
+func (mt *MerkleTree) GenerateMultiProof(indices []int) (*MultiProof, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	if len(indices) == 0 {
+		return nil, ErrNoLeafIndices
+	}
+	sorted := make([]int, 0, len(indices))
+	for _, idx := range indices {
+		if idx < 0 || idx >= len(mt.leaves) {
+			return nil, &IndexOutOfRangeError{Index: idx, Size: len(mt.leaves)}
+		}
+		if mt.leaves[idx].isRemoved() {
+			return nil, &RemovedLeafError{Index: idx}
+		}
+		sorted = append(sorted, idx)
+	}
+	sort.Ints(sorted)
+	leafIndices := sorted[:0]
+	for i, idx := range sorted {
+		if i == 0 || idx != sorted[i-1] {
+			leafIndices = append(leafIndices, idx)
+		}
+	}
+	leafHashes := make([][]byte, 0, len(leafIndices))
+	for _, idx := range leafIndices {
+		leafHashes = append(leafHashes, mt.leaves[idx].Hash())
+	}
+	hashes := collectMultiProofHashes(mt.root, 0, len(mt.leaves), leafIndices, nil)
+	return NewMultiProof(leafIndices, leafHashes, hashes), nil
+}
+
+// collectMultiProofHashes walks the tree like collectSiblingsHashes, but for a set of leaves.
+// It collects the hashes of the largest subtrees which don't contain any of the leaves, left to right.
+func collectMultiProofHashes(n node, lo, remainingLen int, indices []int, hashes [][]byte) [][]byte {
+	if len(indices) == 0 {
+		return append(hashes, n.Hash())
+	}
+	nl, ok := n.(*nonLeaf)
+	if !ok {
+		return hashes
+	}
+	if isUnpaired(nl, remainingLen) {
+		return collectMultiProofHashes(nl.left, lo, remainingLen, indices, hashes)
+	}
+	powerOf2 := nearestSmallerPowerOf2(remainingLen)
+	split := sort.SearchInts(indices, lo+powerOf2)
+	hashes = collectMultiProofHashes(nl.left, lo, powerOf2, indices[:split], hashes)
+	return collectMultiProofHashes(nl.right, lo+powerOf2, remainingLen-powerOf2, indices[split:], hashes)
+}
+
+// VerifyMultiProof checks the provided multi-proof against the Merkle tree.
+// It returns an error if the proof is invalid or doesn't correspond to the leaves in the tree.
+func (mt *MerkleTree) VerifyMultiProof(proof *MultiProof) error {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	for i, idx := range proof.leafIndices {
+		if idx < 0 || idx >= len(mt.leaves) {
+			return &IndexOutOfRangeError{Index: idx, Size: len(mt.leaves)}
+		}
+		if i >= len(proof.leafHashes) {
+			return ErrLeafHashMismatch
+		}
+		if leafHash := mt.leaves[idx].Hash(); !bytes.Equal(proof.leafHashes[i], leafHash) {
+			return &LeafHashMismatchError{Index: idx, Provided: proof.leafHashes[i], Expected: leafHash}
+		}
+	}
+	return verifyMultiProof(proof, mt.root.Hash(), mt.hasher, len(mt.leaves), mt.opts)
+}
+
+// VerifyMultiProof checks the provided multi-proof against the root hash of a tree with leafCount leaves
+// built with the given hashing function, without the tree itself. The options have to match the ones the tree was built with.
+func VerifyMultiProof(proof *MultiProof, root []byte, hasher Hasher, leafCount int, opts ...Option) error {
+	o := newOptions(opts)
+	return verifyMultiProof(proof, root, o.sumHasher(hasher), leafCount, o)
+}
+
+func verifyMultiProof(proof *MultiProof, root []byte, hasher sumHasher, leafCount int, o options) error {
+	if len(proof.leafIndices) == 0 {
+		return ErrNoLeafIndices
+	}
+	for i, idx := range proof.leafIndices {
+		if idx < 0 || idx >= leafCount {
+			return &IndexOutOfRangeError{Index: idx, Size: leafCount}
+		}
+		if i > 0 && idx <= proof.leafIndices[i-1] {
+			return ErrWrongProof
+		}
+	}
+	if len(proof.leafHashes) != len(proof.leafIndices) {
+		return ErrLeafHashMismatch
+	}
+	for i, leafHash := range proof.leafHashes {
+		if isTombstone(leafHash) {
+			return &RemovedLeafError{Index: proof.leafIndices[i]}
+		}
+	}
+	v := &multiProofVerifier{
+		mode:       o.mode,
+		nodeHasher: o.mode.nodeHasher(hasher),
+		leafCount:  leafCount,
+		hashes:     proof.hashes,
+	}
+	calculated, ok := v.verify(0, o.mode.treeHeight(leafCount), proof.leafIndices, proof.leafHashes)
+	if !ok || len(v.hashes) > 0 {
+		return ErrWrongProof
+	}
+	if !bytes.Equal(calculated, root) {
+		return &RootMismatchError{Computed: calculated, Expected: root}
+	}
+	return nil
+}
+
+type multiProofVerifier struct {
+	mode       Mode
+	nodeHasher pairHasher
+	leafCount  int
+	hashes     [][]byte
+}
+
+// verify calculates the hash of the subtree of the given height starting at the leaf with index lo,
+// taking the hashes of the subtrees without any of the leaves from the proof.
+func (v *multiProofVerifier) verify(lo, height int, indices []int, leafHashes [][]byte) ([]byte, bool) {
+	if len(indices) == 0 {
+		if len(v.hashes) == 0 {
+			return nil, false
+		}
+		hash := v.hashes[0]
+		v.hashes = v.hashes[1:]
+		return hash, true
+	}
+	if height == 0 {
+		return leafHashes[0], true
+	}
+	mid := lo + 1<<(height-1)
+	split := sort.SearchInts(indices, mid)
+	left, ok := v.verify(lo, height-1, indices[:split], leafHashes[:split])
+	if !ok {
+		return nil, false
+	}
+	if mid >= v.leafCount {
+		return v.mode.unpairedHash(left, v.nodeHasher), true
+	}
+	right, ok := v.verify(mid, height-1, indices[split:], leafHashes[split:])
+	if !ok {
+		return nil, false
+	}
+	return v.nodeHasher(left, right), true
+}
@@ -0,0 +1,149 @@
+package merkletree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleTree_GenerateMultiProof_Iterations(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			for i := 1; i < 50; i++ {
+				leaves := make([]*Leaf, 0, i)
+				for j := 0; j < i; j++ {
+					leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+				}
+				tree, err := NewMerkleTree(leaves, SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				for k := 0; k < 10; k++ {
+					indices := rnd.Perm(i)[:1+rnd.Intn(i)]
+					proof, err := tree.GenerateMultiProof(indices)
+					require.NoError(t, err)
+					msg := fmt.Sprintf("for %d leaves and indices=%v", i, indices)
+					assert.Len(t, proof.LeafIndices(), len(indices), msg)
+					assert.NoError(t, tree.VerifyMultiProof(proof), msg)
+					assert.NoError(t, VerifyMultiProof(proof, tree.Hash(), SHA256Hasher, i, WithMode(mode)), msg)
+
+					siblings := 0
+					for _, idx := range indices {
+						single, err := tree.GenerateProof(idx)
+						require.NoError(t, err)
+						siblings += len(single.siblingHashes)
+					}
+					assert.LessOrEqual(t, len(proof.Hashes()), siblings, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_GenerateMultiProof(t *testing.T) {
+	leaves := make([]*Leaf, 0, 8)
+	for j := 0; j < 8; j++ {
+		leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+	}
+	tree, err := NewMerkleTree(leaves, SHA256Hasher)
+	require.NoError(t, err)
+
+	proof, err := tree.GenerateMultiProof([]int{5, 0, 1, 5})
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 5}, proof.LeafIndices())
+	assert.Equal(t, [][]byte{leaves[0].Hash(), leaves[1].Hash(), leaves[5].Hash()}, proof.LeafHashes())
+	root := tree.root.(*nonLeaf)
+	assert.Equal(t, [][]byte{
+		root.left.(*nonLeaf).right.Hash(),
+		leaves[4].Hash(),
+		root.right.(*nonLeaf).right.Hash(),
+	}, proof.Hashes())
+
+	all, err := tree.GenerateMultiProof([]int{0, 1, 2, 3, 4, 5, 6, 7})
+	require.NoError(t, err)
+	assert.Empty(t, all.Hashes())
+	assert.NoError(t, tree.VerifyMultiProof(all))
+
+	_, err = tree.GenerateMultiProof(nil)
+	assert.ErrorIs(t, err, ErrNoLeafIndices)
+	_, err = tree.GenerateMultiProof([]int{1, 8})
+	assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+}
+
+func TestMerkleTree_VerifyMultiProof_Errors(t *testing.T) {
+	leaves := make([]*Leaf, 0, 11)
+	for j := 0; j < 11; j++ {
+		leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+	}
+	tree, err := NewMerkleTree(leaves, SHA256Hasher)
+	require.NoError(t, err)
+	proof, err := tree.GenerateMultiProof([]int{2, 7, 10})
+	require.NoError(t, err)
+	other := SHA256Hasher([]byte("other"))
+
+	testCases := []struct {
+		name  string
+		proof *MultiProof
+		err   error
+	}{
+		{
+			"valid proof",
+			proof,
+			nil,
+		},
+		{
+			"leaf hash mismatch",
+			NewMultiProof(proof.leafIndices, [][]byte{proof.leafHashes[0], other, proof.leafHashes[2]}, proof.hashes),
+			ErrLeafHashMismatch,
+		},
+		{
+			"missing leaf hash",
+			NewMultiProof(proof.leafIndices, proof.leafHashes[:2], proof.hashes),
+			ErrLeafHashMismatch,
+		},
+		{
+			"wrong auxiliary hash",
+			NewMultiProof(proof.leafIndices, proof.leafHashes, append([][]byte{other}, proof.hashes[1:]...)),
+			ErrWrongProof,
+		},
+		{
+			"missing auxiliary hash",
+			NewMultiProof(proof.leafIndices, proof.leafHashes, proof.hashes[1:]),
+			ErrWrongProof,
+		},
+		{
+			"extra auxiliary hash",
+			NewMultiProof(proof.leafIndices, proof.leafHashes, append(append([][]byte{}, proof.hashes...), other)),
+			ErrWrongProof,
+		},
+		{
+			"unsorted leaf indices",
+			NewMultiProof([]int{7, 2, 10}, [][]byte{proof.leafHashes[1], proof.leafHashes[0], proof.leafHashes[2]}, proof.hashes),
+			ErrWrongProof,
+		},
+		{
+			"leaf index out of bound",
+			NewMultiProof([]int{2, 7, 11}, proof.leafHashes, proof.hashes),
+			ErrLeafIndexOutOfBound,
+		},
+		{
+			"no leaf indices",
+			NewMultiProof(nil, nil, proof.hashes),
+			ErrNoLeafIndices,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err != nil {
+				assert.ErrorIs(t, tree.VerifyMultiProof(tc.proof), tc.err)
+			} else {
+				assert.NoError(t, tree.VerifyMultiProof(tc.proof))
+			}
+		})
+	}
+
+	assert.ErrorIs(t, VerifyMultiProof(proof, other, SHA256Hasher, 11), ErrWrongProof)
+	assert.ErrorIs(t, VerifyMultiProof(proof, tree.Hash(), SHA256Hasher, 16), ErrWrongProof)
+}
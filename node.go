@@ -16,12 +16,34 @@ type Leaf struct {
 	content    []byte
 	cachedHash []byte
 	hashFunc   func([]byte) []byte
+	// storedID is the ID of the leaf in the store the tree was last saved to, or 0 if it changed since
+	storedID NodeID
 }
 
 func NewLeaf(content []byte) *Leaf {
 	return &Leaf{content: content}
 }
 
+// newHashLeaf creates a leaf which only holds the hash of its content.
+func newHashLeaf(hash []byte) *Leaf {
+	return &Leaf{cachedHash: hash}
+}
+
+// isTombstone reports whether the hash is the hash of a removed leaf.
+func isTombstone(hash []byte) bool {
+	for _, b := range hash {
+		if b != 0 {
+			return false
+		}
+	}
+	return len(hash) > 0
+}
+
+// isRemoved reports whether the leaf stands for a removed leaf.
+func (l *Leaf) isRemoved() bool {
+	return l.content == nil && isTombstone(l.Hash())
+}
+
 func (l *Leaf) Hash() []byte {
 	if len(l.cachedHash) > 0 {
 		return l.cachedHash
@@ -42,21 +64,42 @@ type nonLeaf struct {
 	left       node
 	right      node
 	cachedHash []byte
-	hashFunc   func([]byte) []byte
+	hashFunc   pairHasher
+	// storedID is the ID of the node in the store the tree was last saved to, or 0 if it changed since
+	storedID NodeID
 }
 
-func newNonLeaf(left node, right node, hashFunc func([]byte) []byte) *nonLeaf {
+func newNonLeaf(left node, right node, hashFunc pairHasher) *nonLeaf {
 	return &nonLeaf{left: left, right: right, hashFunc: hashFunc}
 }
 
+// newUnpairedNonLeaf creates a node for a child without a pair. A leaf is paired with itself,
+// while an inner node is paired with a childless node mirroring its hash.
+func newUnpairedNonLeaf(left node, hashFunc pairHasher) *nonLeaf {
+	if left.hasChildren() {
+		return newNonLeaf(left, &nonLeaf{left: left}, hashFunc)
+	}
+	return newNonLeaf(left, left, hashFunc)
+}
+
 func (nl *nonLeaf) Hash() []byte {
 	if len(nl.cachedHash) > 0 {
 		return nl.cachedHash
 	}
-	nl.cachedHash = nl.hashFunc(append(nl.left.Hash(), nl.right.Hash()...))
+	if nl.right == nil {
+		// a childless node only mirrors the hash of the node it pairs with
+		return nl.left.Hash()
+	}
+	nl.cachedHash = nl.hashFunc(nl.left.Hash(), nl.right.Hash())
 	return nl.cachedHash
 }
 
+// resetHash drops the cached hash of the node after one of its descendants changed.
+func (nl *nonLeaf) resetHash() {
+	nl.cachedHash = nil
+	nl.storedID = 0
+}
+
 func (nl *nonLeaf) hasChildren() bool {
 	return nl.left != nil && nl.right != nil
 }
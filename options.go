@@ -0,0 +1,158 @@
+package merkletree
+
+import (
+	"bytes"
+	"math/bits"
+)
+
+// Mode defines how leaves and inner nodes of a tree are hashed and how a node without a pair is handled.
+type Mode int
+
+const (
+	// DuplicateOdd is the default mode. Leaves are hashed as H(content), inner nodes as H(left||right)
+	// and a node without a pair is hashed together with its own copy.
+	DuplicateOdd Mode = iota
+	// RFC6962 is the mode defined by RFC 6962 and RFC 9162 (Certificate Transparency).
+	// Leaves are hashed as H(0x00||content), inner nodes as H(0x01||left||right) and a node
+	// without a pair is promoted to the next level unchanged. The domain separation prevents
+	// second-preimage attacks in which an inner node is passed off as a leaf.
+	RFC6962
+	// SortedPair is the mode compatible with OpenZeppelin MerkleProof and StandardMerkleTree.
+	// Leaves are hashed twice as H(H(content)), the hashes of the children of an inner node are sorted
+	// before hashing them as H(min||max) and a node without a pair is promoted to the next level.
+	// Proofs don't depend on the leaf index, so they can be checked on-chain with MerkleProof.verify
+	// when the tree is built with Keccak256Hasher and the leaves hold ABI-encoded values.
+	// The root equals the one of StandardMerkleTree built from the same leaves sorted by their hashes
+	// as long as StandardMerkleTree arranges them the same way, e.g. for 1, 2, 3, 4, 6 or 8 leaves.
+	SortedPair
+)
+
+const (
+	rfc6962LeafPrefix = 0x00
+	rfc6962NodePrefix = 0x01
+)
+
+var (
+	rfc6962LeafPrefixBytes = []byte{rfc6962LeafPrefix}
+	rfc6962NodePrefixBytes = []byte{rfc6962NodePrefix}
+)
+
+// Option configures a Merkle tree or the verification of its proofs.
+type Option func(*options)
+
+type options struct {
+	mode      Mode
+	workers   int
+	stream    *StreamHasher
+	leafIndex bool
+}
+
+// WithMode sets the hashing mode of the tree. Proofs have to be verified with the same mode the tree was built with.
+func WithMode(mode Mode) Option {
+	return func(o *options) {
+		o.mode = mode
+	}
+}
+
+// WithWorkers sets the number of goroutines hashing the leaves and each level of the tree
+// when it is created. The resulting tree is the same as the one built serially.
+func WithWorkers(workers int) Option {
+	return func(o *options) {
+		o.workers = workers
+	}
+}
+
+// WithStreamHasher makes the tree hash its leaves and inner nodes with the given StreamHasher
+// instead of the Hasher passed along, which may then be nil. The hashes of the children of inner nodes
+// are written to the hash state one after another instead of being concatenated first.
+func WithStreamHasher(hasher *StreamHasher) Option {
+	return func(o *options) {
+		o.stream = hasher
+	}
+}
+
+// WithLeafIndex makes the tree keep a map from the hashes of its leaves to their indices, so IndexOf, IndicesOf
+// and ProofForContent don't have to scan all the leaves. The map is updated whenever the tree is modified.
+func WithLeafIndex() Option {
+	return func(o *options) {
+		o.leafIndex = true
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{mode: DuplicateOdd}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// sumHasher returns the StreamHasher set by WithStreamHasher or the given hasher otherwise.
+func (o options) sumHasher(hasher Hasher) sumHasher {
+	if o.stream != nil {
+		return o.stream
+	}
+	return hasher
+}
+
+// leafHasher returns the function used to hash the content of leaves.
+func (m Mode) leafHasher(hasher sumHasher) func([]byte) []byte {
+	switch m {
+	case RFC6962:
+		return func(data []byte) []byte {
+			return hasher.sum(rfc6962LeafPrefixBytes, data)
+		}
+	case SortedPair:
+		return func(data []byte) []byte {
+			return hasher.sum(hasher.sum(data))
+		}
+	}
+	return func(data []byte) []byte {
+		return hasher.sum(data)
+	}
+}
+
+// pairHasher hashes the hashes of the left and the right child of an inner node.
+type pairHasher func(left, right []byte) []byte
+
+// nodeHasher returns the function used to hash the hashes of the children of an inner node.
+func (m Mode) nodeHasher(hasher sumHasher) pairHasher {
+	switch m {
+	case RFC6962:
+		return func(left, right []byte) []byte {
+			return hasher.sum(rfc6962NodePrefixBytes, left, right)
+		}
+	case SortedPair:
+		// the smaller hash goes first, so the order of the children doesn't matter
+		return func(left, right []byte) []byte {
+			if bytes.Compare(left, right) > 0 {
+				left, right = right, left
+			}
+			return hasher.sum(left, right)
+		}
+	}
+	return func(left, right []byte) []byte {
+		return hasher.sum(left, right)
+	}
+}
+
+// promotesOdd reports whether a node without a pair is promoted to the next level instead of being duplicated.
+func (m Mode) promotesOdd() bool {
+	return m == RFC6962 || m == SortedPair
+}
+
+// unpairedHash returns the hash of an inner node whose left child has the given hash and whose right child is missing.
+func (m Mode) unpairedHash(hash []byte, nodeHasher pairHasher) []byte {
+	if m.promotesOdd() {
+		return hash
+	}
+	return nodeHasher(hash, hash)
+}
+
+// treeHeight returns the number of levels above the leaves in a tree with leafCount leaves.
+func (m Mode) treeHeight(leafCount int) int {
+	if m.promotesOdd() {
+		return bits.Len(uint(leafCount - 1))
+	}
+	return treeDepth(leafCount)
+}
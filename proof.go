@@ -1,6 +1,22 @@
 package merkletree
 
-import "bytes"
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+)
+
+const (
+	// proofEncodingVersion is the version of the binary proof encoding produced by MarshalBinary.
+	proofEncodingVersion = 1
+	// maxProofSiblings limits the number of sibling hashes accepted when decoding a proof.
+	// A leaf index is an int, so no tree can be deeper than 64 levels.
+	maxProofSiblings = 64
+	// maxProofHashSize limits the size of a single hash accepted when decoding a proof.
+	maxProofHashSize = 512
+)
 
 // Proof represents a proof of inclusion in a Merkle tree.
 // It consists of the index of a leaf, the hash of the leaf and
@@ -16,6 +32,22 @@ func NewProof(leafIndex int, leafHash []byte, siblingHashes [][]byte) *Proof {
 	return &Proof{leafIndex: leafIndex, leafHash: leafHash, siblingHashes: siblingHashes}
 }
 
+// LeafIndex returns the index of the leaf the proof was generated for.
+func (p *Proof) LeafIndex() int {
+	return p.leafIndex
+}
+
+// LeafHash returns the hash of the leaf the proof was generated for.
+func (p *Proof) LeafHash() []byte {
+	return p.leafHash
+}
+
+// SiblingHashes returns the hashes of the siblings on the path from the leaf to the root,
+// starting with the sibling of the leaf.
+func (p *Proof) SiblingHashes() [][]byte {
+	return p.siblingHashes
+}
+
 // Equal checks the equality of the current Proof with another Proof.
 // Two proofs are equal if their leaf index, leaf hash and all sibling hashes are identical.
 func (p *Proof) Equal(other *Proof) bool {
@@ -35,3 +67,149 @@ func (p *Proof) Equal(other *Proof) bool {
 	}
 	return true
 }
+
+// MarshalBinary encodes the proof into a compact binary form. The encoding starts with a version byte
+// followed by the leaf index, the leaf hash and the sibling hashes. Numbers are encoded as unsigned
+// varints and every hash is prefixed with its length.
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	if p.leafIndex < 0 {
+		return nil, ErrLeafIndexOutOfBound
+	}
+	size := 1 + 2*binary.MaxVarintLen64 + binary.MaxVarintLen64 + len(p.leafHash)
+	for _, hash := range p.siblingHashes {
+		size += binary.MaxVarintLen64 + len(hash)
+	}
+	data := make([]byte, 0, size)
+	data = append(data, proofEncodingVersion)
+	data = binary.AppendUvarint(data, uint64(p.leafIndex))
+	data = appendHash(data, p.leafHash)
+	data = binary.AppendUvarint(data, uint64(len(p.siblingHashes)))
+	for _, hash := range p.siblingHashes {
+		data = appendHash(data, hash)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a proof encoded with MarshalBinary.
+// It returns an error if the data is truncated, exceeds the size limits or has an unsupported version.
+func (p *Proof) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return ErrTruncatedProof
+	}
+	if data[0] != proofEncodingVersion {
+		return ErrUnsupportedProofVersion
+	}
+	data = data[1:]
+	leafIndex, data, err := readUvarint(data, math.MaxInt)
+	if err != nil {
+		return err
+	}
+	leafHash, data, err := readHash(data)
+	if err != nil {
+		return err
+	}
+	count, data, err := readUvarint(data, maxProofSiblings)
+	if err != nil {
+		return err
+	}
+	siblingHashes := make([][]byte, count)
+	for i := range siblingHashes {
+		siblingHashes[i], data, err = readHash(data)
+		if err != nil {
+			return err
+		}
+	}
+	if len(data) > 0 {
+		return ErrOversizedProof
+	}
+	*p = Proof{leafIndex: int(leafIndex), leafHash: leafHash, siblingHashes: siblingHashes}
+	return nil
+}
+
+type jsonProof struct {
+	LeafIndex     uint64   `json:"leafIndex"`
+	LeafHash      string   `json:"leafHash"`
+	SiblingHashes []string `json:"siblingHashes"`
+}
+
+// MarshalJSON encodes the proof as a JSON object with hashes represented as hex strings.
+func (p *Proof) MarshalJSON() ([]byte, error) {
+	if p.leafIndex < 0 {
+		return nil, ErrLeafIndexOutOfBound
+	}
+	siblingHashes := make([]string, len(p.siblingHashes))
+	for i, hash := range p.siblingHashes {
+		siblingHashes[i] = hex.EncodeToString(hash)
+	}
+	return json.Marshal(jsonProof{
+		LeafIndex:     uint64(p.leafIndex),
+		LeafHash:      hex.EncodeToString(p.leafHash),
+		SiblingHashes: siblingHashes,
+	})
+}
+
+// UnmarshalJSON decodes a proof encoded with MarshalJSON.
+// It applies the same size limits as UnmarshalBinary.
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var jp jsonProof
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+	if jp.LeafIndex > math.MaxInt || len(jp.SiblingHashes) > maxProofSiblings {
+		return ErrOversizedProof
+	}
+	leafHash, err := decodeHexHash(jp.LeafHash)
+	if err != nil {
+		return err
+	}
+	siblingHashes := make([][]byte, len(jp.SiblingHashes))
+	for i, hash := range jp.SiblingHashes {
+		siblingHashes[i], err = decodeHexHash(hash)
+		if err != nil {
+			return err
+		}
+	}
+	*p = Proof{leafIndex: int(jp.LeafIndex), leafHash: leafHash, siblingHashes: siblingHashes}
+	return nil
+}
+
+func appendHash(data, hash []byte) []byte {
+	data = binary.AppendUvarint(data, uint64(len(hash)))
+	return append(data, hash...)
+}
+
+func readUvarint(data []byte, limit uint64) (uint64, []byte, error) {
+	v, n := binary.Uvarint(data)
+	if n == 0 {
+		return 0, nil, ErrTruncatedProof
+	}
+	if n < 0 || v > limit {
+		return 0, nil, ErrOversizedProof
+	}
+	return v, data[n:], nil
+}
+
+func readHash(data []byte) ([]byte, []byte, error) {
+	return readBytes(data, maxProofHashSize)
+}
+
+// readBytes reads a length-prefixed byte slice of at most limit bytes.
+func readBytes(data []byte, limit uint64) ([]byte, []byte, error) {
+	size, data, err := readUvarint(data, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if uint64(len(data)) < size {
+		return nil, nil, ErrTruncatedProof
+	}
+	b := make([]byte, size)
+	copy(b, data)
+	return b, data[size:], nil
+}
+
+func decodeHexHash(s string) ([]byte, error) {
+	if hex.DecodedLen(len(s)) > maxProofHashSize {
+		return nil, ErrOversizedProof
+	}
+	return hex.DecodeString(s)
+}
@@ -1,8 +1,13 @@
 package merkletree
 
 import (
-	"github.com/stretchr/testify/assert"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestProof_Equals(t *testing.T) {
@@ -50,3 +55,132 @@ func TestProof_Equals(t *testing.T) {
 		})
 	}
 }
+
+func TestProof_Encoding_RoundTrip(t *testing.T) {
+	hashers := map[string]Hasher{
+		"md5":        MD5Hasher,
+		"sha256":     SHA256Hasher,
+		"blake2b512": Blake2b512Hasher,
+	}
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			for i := 1; i < 40; i++ {
+				leaves := make([]*Leaf, 0, i)
+				for j := 0; j < i; j++ {
+					leaves = append(leaves, NewLeaf([]byte(fmt.Sprintf("%d", j))))
+				}
+				tree, err := NewMerkleTree(leaves, hasher)
+				require.NoError(t, err)
+				for j := 0; j < i; j++ {
+					proof, err := tree.GenerateProof(j)
+					require.NoError(t, err)
+
+					data, err := proof.MarshalBinary()
+					require.NoError(t, err)
+					decoded := &Proof{}
+					require.NoError(t, decoded.UnmarshalBinary(data))
+					assert.True(t, proof.Equal(decoded), fmt.Sprintf("binary for %d leaves and index=%d", i, j))
+					assert.NoError(t, tree.VerifyProof(decoded))
+
+					data, err = json.Marshal(proof)
+					require.NoError(t, err)
+					decoded = &Proof{}
+					require.NoError(t, json.Unmarshal(data, decoded))
+					assert.True(t, proof.Equal(decoded), fmt.Sprintf("json for %d leaves and index=%d", i, j))
+					assert.NoError(t, tree.VerifyProof(decoded))
+				}
+			}
+		})
+	}
+}
+
+func TestProof_Getters(t *testing.T) {
+	proof := NewProof(3, []byte{1, 2, 3}, [][]byte{{4, 5, 6}, {7, 8, 9}})
+	assert.Equal(t, 3, proof.LeafIndex())
+	assert.Equal(t, []byte{1, 2, 3}, proof.LeafHash())
+	assert.Equal(t, [][]byte{{4, 5, 6}, {7, 8, 9}}, proof.SiblingHashes())
+}
+
+func TestProof_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(NewProof(3, []byte{1, 2, 3}, [][]byte{{4, 5, 6}, {7, 8, 9}}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"leafIndex":3,"leafHash":"010203","siblingHashes":["040506","070809"]}`, string(data))
+}
+
+func TestProof_UnmarshalBinary_Errors(t *testing.T) {
+	valid, err := NewProof(300, []byte{1, 2, 3}, [][]byte{{4, 5, 6}, {7, 8, 9}}).MarshalBinary()
+	require.NoError(t, err)
+
+	for i := 0; i < len(valid); i++ {
+		assert.ErrorIs(t, (&Proof{}).UnmarshalBinary(valid[:i]), ErrTruncatedProof, fmt.Sprintf("prefix of length %d", i))
+	}
+
+	testCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{
+			"unsupported version",
+			append([]byte{2}, valid[1:]...),
+			ErrUnsupportedProofVersion,
+		},
+		{
+			"trailing data",
+			append(append([]byte{}, valid...), 0),
+			ErrOversizedProof,
+		},
+		{
+			"too many siblings",
+			[]byte{proofEncodingVersion, 0, 0, maxProofSiblings + 1},
+			ErrOversizedProof,
+		},
+		{
+			"too long hash",
+			[]byte{proofEncodingVersion, 0, 0x81, 0x04},
+			ErrOversizedProof,
+		},
+		{
+			"leaf index overflow",
+			[]byte{proofEncodingVersion, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0, 0},
+			ErrOversizedProof,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorIs(t, (&Proof{}).UnmarshalBinary(tc.data), tc.err)
+		})
+	}
+}
+
+func TestProof_UnmarshalJSON_Errors(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{
+			"too many siblings",
+			fmt.Sprintf(`{"leafIndex":0,"leafHash":"01","siblingHashes":[%s"01"]}`, strings.Repeat(`"01",`, maxProofSiblings)),
+			ErrOversizedProof,
+		},
+		{
+			"too long hash",
+			fmt.Sprintf(`{"leafIndex":0,"leafHash":"%s","siblingHashes":[]}`, strings.Repeat("01", maxProofHashSize+1)),
+			ErrOversizedProof,
+		},
+		{
+			"leaf index overflow",
+			`{"leafIndex":18446744073709551615,"leafHash":"01","siblingHashes":[]}`,
+			ErrOversizedProof,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.ErrorIs(t, json.Unmarshal([]byte(tc.data), &Proof{}), tc.err)
+		})
+	}
+
+	assert.Error(t, json.Unmarshal([]byte(`{"leafIndex":-1,"leafHash":"01","siblingHashes":[]}`), &Proof{}))
+	assert.Error(t, json.Unmarshal([]byte(`{"leafIndex":0,"leafHash":"zz","siblingHashes":[]}`), &Proof{}))
+}
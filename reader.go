@@ -0,0 +1,51 @@
+package merkletree
+
+import (
+	"errors"
+	"io"
+)
+
+// NewMerkleTreeFromReader creates a new Merkle tree from the data read from r until io.EOF, split into
+// leaves of chunkSize bytes. The last leaf holds the remaining data and may be shorter.
+// Only the hashes of the leaves are kept, so the memory used depends on the number of chunks and not
+// on the size of the data. Proofs can be generated for every chunk, while the content of the leaves is empty.
+// It returns ErrEmptyTree if r is empty.
+func NewMerkleTreeFromReader(r io.Reader, chunkSize int, hasher Hasher, opts ...Option) (*MerkleTree, error) {
+	if chunkSize <= 0 {
+		return nil, ErrInvalidChunkSize
+	}
+	chunk := make([]byte, chunkSize)
+	return newMerkleTreeFromChunks(func() ([]byte, error) {
+		n, err := io.ReadFull(r, chunk)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = nil
+		}
+		return chunk[:n], err
+	}, hasher, opts)
+}
+
+// NewMerkleTreeFromChunker creates a new Merkle tree with a leaf for every chunk returned by the chunker.
+// Like NewMerkleTreeFromReader it only keeps the hashes of the leaves.
+// It returns ErrEmptyTree if the chunker doesn't return any chunk.
+func NewMerkleTreeFromChunker(c *Chunker, hasher Hasher, opts ...Option) (*MerkleTree, error) {
+	return newMerkleTreeFromChunks(c.Next, hasher, opts)
+}
+
+// newMerkleTreeFromChunks creates a tree of hash-only leaves from the chunks returned by next until io.EOF.
+// A chunk may be overwritten by the next call.
+func newMerkleTreeFromChunks(next func() ([]byte, error), hasher Hasher, opts []Option) (*MerkleTree, error) {
+	o := newOptions(opts)
+	leafHasher := o.mode.leafHasher(o.sumHasher(hasher))
+	var leaves []*Leaf
+	for {
+		chunk, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, newHashLeaf(leafHasher(chunk)))
+	}
+	return NewMerkleTree(leaves, hasher, opts...)
+}
@@ -0,0 +1,95 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMerkleTreeFromReader(t *testing.T) {
+	data := make([]byte, 10_000)
+	rand.New(rand.NewSource(1)).Read(data)
+	for name, mode := range testModes {
+		for _, chunkSize := range []int{1_000, 1_024, 3_333, 10_000, 20_000} {
+			t.Run(fmt.Sprintf("%s %d bytes chunks", name, chunkSize), func(t *testing.T) {
+				var leaves []*Leaf
+				for lo := 0; lo < len(data); lo += chunkSize {
+					hi := lo + chunkSize
+					if hi > len(data) {
+						hi = len(data)
+					}
+					leaves = append(leaves, NewLeaf(data[lo:hi]))
+				}
+				expected, err := NewMerkleTree(leaves, SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+
+				tree, err := NewMerkleTreeFromReader(iotest.HalfReader(bytes.NewReader(data)), chunkSize, SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				assert.Equal(t, expected.Hash(), tree.Hash())
+				require.Len(t, tree.leaves, len(leaves))
+				for i, leaf := range tree.leaves {
+					assert.Nil(t, leaf.content)
+					proof, err := tree.GenerateProof(i)
+					require.NoError(t, err)
+					assert.Equal(t, leaves[i].Hash(), proof.LeafHash())
+					assert.NoError(t, VerifyProof(proof, expected.Hash(), SHA256Hasher, len(leaves), WithMode(mode)))
+				}
+			})
+		}
+	}
+}
+
+func TestNewMerkleTreeFromReader_UpdateAndAppend(t *testing.T) {
+	tree, err := NewMerkleTreeFromReader(bytes.NewReader([]byte("onetwothree")), 3, SHA256Hasher)
+	require.NoError(t, err)
+	require.NoError(t, tree.Update(1, []byte("TWO")))
+	tree.Append(NewLeaf([]byte("four")))
+
+	expected, err := NewMerkleTree(newLeaves([][]byte{
+		[]byte("one"), []byte("TWO"), []byte("thr"), []byte("ee"), []byte("four"),
+	}), SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Hash(), tree.Hash())
+}
+
+func TestNewMerkleTreeFromReader_Errors(t *testing.T) {
+	_, err := NewMerkleTreeFromReader(bytes.NewReader([]byte("data")), 0, SHA256Hasher)
+	assert.ErrorIs(t, err, ErrInvalidChunkSize)
+
+	_, err = NewMerkleTreeFromReader(bytes.NewReader(nil), 4, SHA256Hasher)
+	assert.ErrorIs(t, err, ErrEmptyTree)
+
+	readErr := errors.New("read failed")
+	_, err = NewMerkleTreeFromReader(io.MultiReader(bytes.NewReader([]byte("data")), iotest.ErrReader(readErr)), 2, SHA256Hasher)
+	assert.ErrorIs(t, err, readErr)
+}
+
+func BenchmarkNewMerkleTreeFromReader(b *testing.B) {
+	const size = 256 << 20
+	for _, chunkSize := range []int{4 << 10, 64 << 10} {
+		b.Run(fmt.Sprintf("%d MiB %d KiB chunks", size>>20, chunkSize>>10), func(b *testing.B) {
+			b.SetBytes(size)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := NewMerkleTreeFromReader(io.LimitReader(zeroReader{}, size), chunkSize, nil, WithStreamHasher(SHA256StreamHasher))
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
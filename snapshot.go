@@ -0,0 +1,248 @@
+package merkletree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+const snapshotVersion = 1
+
+// snapshotFlagContents marks a snapshot holding the contents of the leaves.
+const snapshotFlagContents = 1 << 0
+
+var snapshotMagic = []byte("MKTS")
+
+// knownHashers assigns the IDs stored in snapshots to the hashing functions of the package.
+// The ID 0 stands for any other hashing function. The IDs must never change.
+var knownHashers = []struct {
+	id     byte
+	hasher Hasher
+}{
+	{1, SHA256Hasher},
+	{2, SHA512Hasher},
+	{3, Blake2b256Hasher},
+	{4, Blake2b512Hasher},
+	{5, Keccak256Hasher},
+	{6, SHA3_256Hasher},
+	{7, SHA3_512Hasher},
+	{8, MD5Hasher},
+}
+
+var hasherProbe = []byte("merkletree hasher probe")
+
+// hasherID returns the ID of the known hashing function producing the same hash of a probe as the given one,
+// or 0 if there is none. Functions can't be compared in Go, so this is the only way to recognize them.
+func hasherID(hasher sumHasher) byte {
+	probe := hasher.sum(hasherProbe)
+	for _, known := range knownHashers {
+		if bytes.Equal(known.hasher(hasherProbe), probe) {
+			return known.id
+		}
+	}
+	return 0
+}
+
+// WriteTo writes a snapshot of the tree to w without the contents of the leaves, implementing io.WriterTo.
+// It is the same as WriteSnapshot(w, false).
+func (mt *MerkleTree) WriteTo(w io.Writer) (int64, error) {
+	return mt.WriteSnapshot(w, false)
+}
+
+// WriteSnapshot writes a snapshot of the tree to w. A snapshot can be read with ReadMerkleTree and holds
+// the hashes of the leaves, so trees are moved between services without sending the raw data again.
+// The contents of the leaves are only included if withContents is true.
+//
+// A snapshot starts with the magic bytes "MKTS", a version byte, the ID of the hashing function (0 if it isn't one
+// of the functions of this package), the mode and a byte of flags. Then come the size of the hashes and the number
+// of leaves as unsigned varints, followed by the root hash and the hashes of the leaves. If the contents are included
+// and any leaf has content, the contents of all the leaves follow, each prefixed with its length plus one,
+// or 0 for a leaf holding only its hash.
+func (mt *MerkleTree) WriteSnapshot(w io.Writer, withContents bool) (int64, error) {
+	mt.mu.RLock()
+	defer mt.mu.RUnlock()
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	var flags byte
+	for _, leaf := range mt.leaves {
+		if withContents && leaf.content != nil {
+			flags |= snapshotFlagContents
+			break
+		}
+	}
+	root := mt.root.Hash()
+	header := append([]byte{}, snapshotMagic...)
+	header = append(header, snapshotVersion, hasherID(mt.hasher), byte(mt.opts.mode), flags)
+	header = binary.AppendUvarint(header, uint64(len(root)))
+	header = binary.AppendUvarint(header, uint64(len(mt.leaves)))
+	bw.Write(header)
+	bw.Write(root)
+	for _, leaf := range mt.leaves {
+		bw.Write(leaf.Hash())
+	}
+	if flags&snapshotFlagContents != 0 {
+		var size []byte
+		for _, leaf := range mt.leaves {
+			if leaf.content == nil {
+				bw.WriteByte(0)
+				continue
+			}
+			size = binary.AppendUvarint(size[:0], uint64(len(leaf.content))+1)
+			bw.Write(size)
+			bw.Write(leaf.content)
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ReadMerkleTree reads a snapshot written by MerkleTree.WriteTo or MerkleTree.WriteSnapshot. The tree is built with the given hashing function,
+// which has to be the same as the one of the snapshot, and the mode stored in the snapshot. The other options apply
+// as usual. The hashes of the leaves with content and the root hash are calculated again and compared with
+// the stored ones, returning a *LeafHashMismatchError or a *SnapshotRootMismatchError if they differ.
+// If r isn't an io.ByteReader, data following the snapshot may be read from r as well.
+func ReadMerkleTree(r io.Reader, hasher Hasher, opts ...Option) (*MerkleTree, error) {
+	br, ok := r.(snapshotReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	header := make([]byte, len(snapshotMagic)+4)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, snapshotReadError(err)
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return nil, ErrInvalidSnapshot
+	}
+	header = header[len(snapshotMagic):]
+	if header[0] != snapshotVersion {
+		return nil, ErrUnsupportedSnapshotVersion
+	}
+	id, mode, flags := header[1], Mode(header[2]), header[3]
+	if mode > SortedPair || flags&^snapshotFlagContents != 0 {
+		return nil, ErrInvalidSnapshot
+	}
+	opts = append(opts[:len(opts):len(opts)], WithMode(mode))
+	o := newOptions(opts)
+	h := o.sumHasher(hasher)
+	if id != 0 && hasherID(h) != id {
+		return nil, ErrSnapshotHasherMismatch
+	}
+
+	hashSize, err := readSnapshotUvarint(br, maxProofHashSize)
+	if err != nil {
+		return nil, err
+	}
+	count, err := readSnapshotUvarint(br, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+	if hashSize == 0 || count == 0 {
+		return nil, ErrInvalidSnapshot
+	}
+	root, err := readSnapshotBytes(br, hashSize)
+	if err != nil {
+		return nil, err
+	}
+	// the leaves are allocated as they are read, so a forged count can't exhaust memory
+	leaves := make([]*Leaf, 0, minInt(int(count), 1<<16))
+	for i := uint64(0); i < count; i++ {
+		hash, err := readSnapshotBytes(br, hashSize)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, newHashLeaf(hash))
+	}
+	if flags&snapshotFlagContents != 0 {
+		leafHasher := o.mode.leafHasher(h)
+		for i, leaf := range leaves {
+			size, err := readSnapshotUvarint(br, math.MaxInt)
+			if err != nil {
+				return nil, err
+			}
+			if size == 0 {
+				continue
+			}
+			content, err := readSnapshotBytes(br, size-1)
+			if err != nil {
+				return nil, err
+			}
+			if hash := leafHasher(content); !bytes.Equal(hash, leaf.cachedHash) {
+				return nil, &LeafHashMismatchError{Index: i, Provided: leaf.cachedHash, Expected: hash}
+			}
+			leaf.content = content
+		}
+	}
+
+	mt, err := NewMerkleTree(leaves, hasher, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if computed := mt.Hash(); !bytes.Equal(computed, root) {
+		return nil, &SnapshotRootMismatchError{Computed: computed, Stored: root}
+	}
+	return mt, nil
+}
+
+type snapshotReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readSnapshotUvarint(r io.ByteReader, limit uint64) (uint64, error) {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, snapshotReadError(err)
+	}
+	if v > limit {
+		return 0, ErrInvalidSnapshot
+	}
+	return v, nil
+}
+
+// readSnapshotBytes reads size bytes. Large buffers only grow as the data arrives.
+func readSnapshotBytes(r io.Reader, size uint64) ([]byte, error) {
+	if size <= maxProofHashSize {
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, snapshotReadError(err)
+		}
+		return data, nil
+	}
+	if size > math.MaxInt64 {
+		return nil, ErrInvalidSnapshot
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		return nil, snapshotReadError(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// snapshotReadError reports a snapshot ending too early as invalid.
+func snapshotReadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrInvalidSnapshot
+	}
+	return err
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
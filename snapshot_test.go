@@ -0,0 +1,164 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleTree_WriteTo(t *testing.T) {
+	for name, mode := range testModes {
+		for _, size := range []int{1, 2, 7, 16} {
+			t.Run(fmt.Sprintf("%s %d leaves", name, size), func(t *testing.T) {
+				contents := make([][]byte, size)
+				for i := range contents {
+					contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+				}
+				tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+
+				var hashOnly bytes.Buffer
+				n, err := tree.WriteTo(&hashOnly)
+				require.NoError(t, err)
+				assert.Equal(t, int64(hashOnly.Len()), n)
+				var withContents bytes.Buffer
+				n, err = tree.WriteSnapshot(&withContents, true)
+				require.NoError(t, err)
+				assert.Equal(t, int64(withContents.Len()), n)
+				assert.Greater(t, withContents.Len(), hashOnly.Len())
+
+				// the mode is taken from the snapshot
+				read, err := ReadMerkleTree(&hashOnly, SHA256Hasher)
+				require.NoError(t, err)
+				assert.Equal(t, tree.Hash(), read.Hash())
+				assert.Equal(t, make([][]byte, size), leafContents(read))
+				proof, err := read.GenerateProof(size - 1)
+				require.NoError(t, err)
+				assert.NoError(t, tree.VerifyProof(proof))
+
+				read, err = ReadMerkleTree(&withContents, SHA256Hasher)
+				require.NoError(t, err)
+				assert.Equal(t, tree.Hash(), read.Hash())
+				assert.Equal(t, contents, leafContents(read))
+				proof, err = read.GenerateProof(size - 1)
+				require.NoError(t, err)
+				assert.NoError(t, tree.VerifyProof(proof))
+			})
+		}
+	}
+}
+
+func leafContents(tree *MerkleTree) [][]byte {
+	contents := make([][]byte, len(tree.leaves))
+	for i, leaf := range tree.leaves {
+		contents[i] = leaf.content
+	}
+	return contents
+}
+
+func TestMerkleTree_WriteTo_HashOnlyLeaves(t *testing.T) {
+	tree, err := NewMerkleTreeFromReader(bytes.NewReader([]byte("onetwothree")), 3, SHA256Hasher)
+	require.NoError(t, err)
+	var hashOnly bytes.Buffer
+	_, err = tree.WriteSnapshot(&hashOnly, true)
+	require.NoError(t, err)
+	read, err := ReadMerkleTree(bytes.NewReader(hashOnly.Bytes()), SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), read.Hash())
+	assert.Equal(t, [][]byte{nil, nil, nil, nil}, leafContents(read))
+
+	// only the updated leaf has content
+	require.NoError(t, tree.Update(1, []byte("TWO")))
+	var mixed bytes.Buffer
+	_, err = tree.WriteSnapshot(&mixed, true)
+	require.NoError(t, err)
+	assert.Greater(t, mixed.Len(), hashOnly.Len())
+	read, err = ReadMerkleTree(&mixed, SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), read.Hash())
+	assert.Equal(t, [][]byte{nil, []byte("TWO"), nil, nil}, leafContents(read))
+}
+
+func TestReadMerkleTree_Hashers(t *testing.T) {
+	custom := func(data []byte) []byte {
+		return SHA256Hasher(append([]byte("custom"), data...))
+	}
+	hashers := map[string]Hasher{
+		"sha512":   SHA512Hasher,
+		"keccak":   Keccak256Hasher,
+		"shake256": NewSHAKE256Hasher(64),
+		"custom":   custom,
+	}
+	for name, hasher := range hashers {
+		t.Run(name, func(t *testing.T) {
+			tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), hasher)
+			require.NoError(t, err)
+			var buf bytes.Buffer
+			_, err = tree.WriteTo(&buf)
+			require.NoError(t, err)
+			read, err := ReadMerkleTree(bytes.NewReader(buf.Bytes()), hasher)
+			require.NoError(t, err)
+			assert.Equal(t, tree.Hash(), read.Hash())
+		})
+	}
+
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two")}), nil, WithStreamHasher(SHA256StreamHasher))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = tree.WriteTo(&buf)
+	require.NoError(t, err)
+	_, err = ReadMerkleTree(bytes.NewReader(buf.Bytes()), SHA512Hasher)
+	assert.ErrorIs(t, err, ErrSnapshotHasherMismatch)
+	read, err := ReadMerkleTree(bytes.NewReader(buf.Bytes()), SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), read.Hash())
+}
+
+func TestReadMerkleTree_Errors(t *testing.T) {
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = tree.WriteSnapshot(&buf, true)
+	require.NoError(t, err)
+	valid := buf.Bytes()
+	// magic, version, hasher, mode, flags, hash size and leaf count
+	const rootOffset = 4 + 4 + 2
+	contentsOffset := rootOffset + 4*32
+
+	modified := func(offset int, value byte) []byte {
+		data := append([]byte{}, valid...)
+		data[offset] = value
+		return data
+	}
+	testCases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"empty", nil, ErrInvalidSnapshot},
+		{"wrong magic", modified(0, 'X'), ErrInvalidSnapshot},
+		{"unsupported version", modified(4, 2), ErrUnsupportedSnapshotVersion},
+		{"unknown mode", modified(6, 9), ErrInvalidSnapshot},
+		{"unknown flag", modified(7, 2), ErrInvalidSnapshot},
+		{"truncated", valid[:len(valid)-1], ErrInvalidSnapshot},
+		{"zero leaves", modified(9, 0), ErrInvalidSnapshot},
+		{"wrong root", modified(rootOffset, valid[rootOffset]^1), ErrSnapshotRootMismatch},
+		{"wrong leaf hash", modified(rootOffset+32, valid[rootOffset+32]^1), ErrLeafHashMismatch},
+		{"wrong content", modified(contentsOffset+1, 'O'), ErrLeafHashMismatch},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ReadMerkleTree(bytes.NewReader(tc.data), SHA256Hasher)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+
+	_, err = ReadMerkleTree(bytes.NewReader(modified(rootOffset, valid[rootOffset]^1)), SHA256Hasher)
+	var rootErr *SnapshotRootMismatchError
+	require.True(t, errors.As(err, &rootErr))
+	assert.Equal(t, tree.Hash(), rootErr.Computed)
+}
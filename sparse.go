@@ -0,0 +1,316 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// sparseDepth is the number of levels above the leaves in a SparseMerkleTree, one for every bit of a key.
+const sparseDepth = 256
+
+// SparseKey is a 256-bit key addressing a leaf of a SparseMerkleTree.
+// Keys of arbitrary length can be mapped to it with a 256-bit hashing function.
+type SparseKey [32]byte
+
+// bit returns the bit of the key which selects the child of a node at the given depth, 0 being the root.
+func (k SparseKey) bit(depth int) byte {
+	return (k[depth/8] >> (7 - depth%8)) & 1
+}
+
+// prefix returns the key with the bits below the given height cleared,
+// which identifies the node of that height on the path of the key.
+func (k SparseKey) prefix(height int) SparseKey {
+	var p SparseKey
+	depth := sparseDepth - height
+	copy(p[:depth/8], k[:depth/8])
+	if depth%8 != 0 {
+		p[depth/8] = k[depth/8] & (0xff << (8 - depth%8))
+	}
+	return p
+}
+
+// sibling returns the prefix of the sibling of the node of the given height on the path of the key.
+func (k SparseKey) sibling(height int) SparseKey {
+	p := k.prefix(height)
+	depth := sparseDepth - height - 1
+	p[depth/8] ^= 1 << (7 - depth%8)
+	return p
+}
+
+type sparseNodeKey struct {
+	height int
+	prefix SparseKey
+}
+
+// SparseMerkleTree is a Merkle tree with a leaf for every possible 256-bit key, most of which are empty.
+// Leaves are hashed as H(0x00||key||value) and inner nodes as H(0x01||left||right). The hashes of empty
+// subtrees are precomputed, so only the nodes on the paths of the stored keys are kept.
+// Besides proving that a key has a value, it can prove that a key is absent from the tree.
+//
+// A SparseMerkleTree is safe for concurrent use by multiple readers and a single writer.
+type SparseMerkleTree struct {
+	mu       sync.RWMutex
+	hasher   Hasher
+	defaults [][]byte
+	nodes    map[sparseNodeKey][]byte
+	values   map[SparseKey][]byte
+}
+
+// NewSparseMerkleTree creates an empty sparse Merkle tree given a hashing function.
+func NewSparseMerkleTree(hasher Hasher) *SparseMerkleTree {
+	return &SparseMerkleTree{
+		hasher:   hasher,
+		defaults: sparseDefaultHashes(hasher),
+		nodes:    make(map[sparseNodeKey][]byte),
+		values:   make(map[SparseKey][]byte),
+	}
+}
+
+// sparseDefaultHashes returns the hashes of empty subtrees of every height.
+func sparseDefaultHashes(hasher Hasher) [][]byte {
+	defaults := make([][]byte, sparseDepth+1)
+	defaults[0] = hasher(nil)
+	nodeHasher := RFC6962.nodeHasher(hasher)
+	for h := 1; h <= sparseDepth; h++ {
+		defaults[h] = nodeHasher(defaults[h-1], defaults[h-1])
+	}
+	return defaults
+}
+
+func sparseLeafHash(key SparseKey, value []byte, hasher Hasher) []byte {
+	data := make([]byte, 0, 1+len(key)+len(value))
+	data = append(data, rfc6962LeafPrefix)
+	data = append(data, key[:]...)
+	return hasher(append(data, value...))
+}
+
+// Hash returns the root hash of the sparse Merkle tree.
+func (t *SparseMerkleTree) Hash() []byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nodeHash(sparseDepth, SparseKey{})
+}
+
+// Get returns the value stored under the key and whether the key is present in the tree.
+func (t *SparseMerkleTree) Get(key SparseKey) ([]byte, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	value, ok := t.values[key]
+	return value, ok
+}
+
+// Set stores the value under the key and recalculates the hashes on the path of the key.
+func (t *SparseMerkleTree) Set(key SparseKey, value []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values[key] = value
+	t.updatePath(key, sparseLeafHash(key, value, t.hasher))
+}
+
+// Delete removes the key from the tree and recalculates the hashes on the path of the key.
+func (t *SparseMerkleTree) Delete(key SparseKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.values[key]; !ok {
+		return
+	}
+	delete(t.values, key)
+	t.updatePath(key, t.defaults[0])
+}
+
+func (t *SparseMerkleTree) updatePath(key SparseKey, hash []byte) {
+	nodeHasher := RFC6962.nodeHasher(t.hasher)
+	for h := 0; ; h++ {
+		nk := sparseNodeKey{height: h, prefix: key.prefix(h)}
+		if bytes.Equal(hash, t.defaults[h]) {
+			delete(t.nodes, nk)
+		} else {
+			t.nodes[nk] = hash
+		}
+		if h == sparseDepth {
+			return
+		}
+		sibling := t.nodeHash(h, key.sibling(h))
+		if key.bit(sparseDepth-h-1) == 0 {
+			hash = nodeHasher(hash, sibling)
+		} else {
+			hash = nodeHasher(sibling, hash)
+		}
+	}
+}
+
+func (t *SparseMerkleTree) nodeHash(height int, prefix SparseKey) []byte {
+	if hash, ok := t.nodes[sparseNodeKey{height: height, prefix: prefix}]; ok {
+		return hash
+	}
+	return t.defaults[height]
+}
+
+// GenerateProof creates a proof for the key. If the key is present in the tree it proves that the key
+// has its current value, otherwise it proves that the key is absent.
+func (t *SparseMerkleTree) GenerateProof(key SparseKey) *SparseProof {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	value, exists := t.values[key]
+	proof := &SparseProof{key: key, value: value, exists: exists}
+	for h := 0; h < sparseDepth; h++ {
+		sibling := t.nodeHash(h, key.sibling(h))
+		if !bytes.Equal(sibling, t.defaults[h]) {
+			proof.bitmap[h/8] |= 1 << (h % 8)
+			proof.siblingHashes = append(proof.siblingHashes, sibling)
+		}
+	}
+	return proof
+}
+
+// SparseProof represents a proof that a key of a sparse Merkle tree has a value (inclusion)
+// or that the key is absent (exclusion). Sibling hashes of empty subtrees are left out
+// and marked in a bitmap instead.
+type SparseProof struct {
+	key           SparseKey
+	value         []byte
+	exists        bool
+	bitmap        [sparseDepth / 8]byte
+	siblingHashes [][]byte
+}
+
+// NewSparseProof creates a new instance of SparseProof given a key, its value, whether the key exists,
+// a bitmap with the bit h%8 of byte h/8 set for every height h whose sibling is not an empty subtree
+// and the hashes of those siblings, starting with the lowest one.
+func NewSparseProof(key SparseKey, value []byte, exists bool, bitmap [sparseDepth / 8]byte, siblingHashes [][]byte) *SparseProof {
+	return &SparseProof{key: key, value: value, exists: exists, bitmap: bitmap, siblingHashes: siblingHashes}
+}
+
+// Key returns the key the proof was generated for.
+func (p *SparseProof) Key() SparseKey {
+	return p.key
+}
+
+// Value returns the value of the key. It is nil for an exclusion proof.
+func (p *SparseProof) Value() []byte {
+	return p.value
+}
+
+// Exists reports whether the proof is an inclusion proof.
+func (p *SparseProof) Exists() bool {
+	return p.exists
+}
+
+// Bitmap returns the bitmap marking the heights whose siblings are not empty subtrees, see NewSparseProof.
+func (p *SparseProof) Bitmap() [sparseDepth / 8]byte {
+	return p.bitmap
+}
+
+// SiblingHashes returns the hashes of the siblings which are not empty subtrees, starting with the lowest one.
+func (p *SparseProof) SiblingHashes() [][]byte {
+	return p.siblingHashes
+}
+
+// MarshalBinary encodes the proof into a compact binary form. The encoding starts with a version byte
+// followed by the key, whether the key exists, the value of an existing key, the bitmap and the sibling hashes.
+// Numbers are encoded as unsigned varints and the value and every hash are prefixed with their length.
+func (p *SparseProof) MarshalBinary() ([]byte, error) {
+	size := 1 + len(p.key) + 2*binary.MaxVarintLen64 + len(p.value) + len(p.bitmap)
+	for _, hash := range p.siblingHashes {
+		size += binary.MaxVarintLen64 + len(hash)
+	}
+	data := make([]byte, 0, size)
+	data = append(data, proofEncodingVersion)
+	data = append(data, p.key[:]...)
+	if p.exists {
+		data = binary.AppendUvarint(data, 1)
+		data = binary.AppendUvarint(data, uint64(len(p.value)))
+		data = append(data, p.value...)
+	} else {
+		data = binary.AppendUvarint(data, 0)
+	}
+	data = append(data, p.bitmap[:]...)
+	for _, hash := range p.siblingHashes {
+		data = appendHash(data, hash)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a proof encoded with MarshalBinary. The number of sibling hashes follows from the bitmap.
+// It returns an error if the data is truncated, exceeds the size limits or has an unsupported version.
+func (p *SparseProof) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return ErrTruncatedProof
+	}
+	if data[0] != proofEncodingVersion {
+		return ErrUnsupportedProofVersion
+	}
+	data = data[1:]
+	var key SparseKey
+	if len(data) < len(key) {
+		return ErrTruncatedProof
+	}
+	data = data[copy(key[:], data):]
+	exists, data, err := readUvarint(data, 1)
+	if err != nil {
+		return err
+	}
+	var value []byte
+	if exists == 1 {
+		if value, data, err = readBytes(data, math.MaxInt); err != nil {
+			return err
+		}
+	}
+	var bitmap [sparseDepth / 8]byte
+	if len(data) < len(bitmap) {
+		return ErrTruncatedProof
+	}
+	data = data[copy(bitmap[:], data):]
+	count := 0
+	for _, b := range bitmap {
+		count += bits.OnesCount8(b)
+	}
+	siblingHashes := make([][]byte, count)
+	for i := range siblingHashes {
+		siblingHashes[i], data, err = readHash(data)
+		if err != nil {
+			return err
+		}
+	}
+	if len(data) > 0 {
+		return ErrOversizedProof
+	}
+	*p = SparseProof{key: key, value: value, exists: exists == 1, bitmap: bitmap, siblingHashes: siblingHashes}
+	return nil
+}
+
+// VerifySparseProof checks the provided inclusion or exclusion proof against the root hash of a sparse
+// Merkle tree built with the given hashing function.
+func VerifySparseProof(proof *SparseProof, root []byte, hasher Hasher) error {
+	defaults := sparseDefaultHashes(hasher)
+	nodeHasher := RFC6962.nodeHasher(hasher)
+	hash := defaults[0]
+	if proof.exists {
+		hash = sparseLeafHash(proof.key, proof.value, hasher)
+	}
+	siblingHashes := proof.siblingHashes
+	for h := 0; h < sparseDepth; h++ {
+		sibling := defaults[h]
+		if proof.bitmap[h/8]&(1<<(h%8)) != 0 {
+			if len(siblingHashes) == 0 {
+				return ErrWrongProof
+			}
+			sibling, siblingHashes = siblingHashes[0], siblingHashes[1:]
+		}
+		if proof.key.bit(sparseDepth-h-1) == 0 {
+			hash = nodeHasher(hash, sibling)
+		} else {
+			hash = nodeHasher(sibling, hash)
+		}
+	}
+	if len(siblingHashes) > 0 {
+		return ErrWrongProof
+	}
+	if !bytes.Equal(hash, root) {
+		return &RootMismatchError{Computed: hash, Expected: root}
+	}
+	return nil
+}
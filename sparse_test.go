@@ -0,0 +1,176 @@
+package merkletree
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sparseKey(s string) SparseKey {
+	return sha256.Sum256([]byte(s))
+}
+
+func TestSparseMerkleTree_SetGetDelete(t *testing.T) {
+	tree := NewSparseMerkleTree(SHA256Hasher)
+	empty := tree.Hash()
+	assert.Equal(t, tree.defaults[sparseDepth], empty)
+
+	tree.Set(sparseKey("one"), []byte("1"))
+	tree.Set(sparseKey("two"), []byte("2"))
+	value, ok := tree.Get(sparseKey("one"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+	_, ok = tree.Get(sparseKey("three"))
+	assert.False(t, ok)
+	assert.NotEqual(t, empty, tree.Hash())
+
+	tree.Set(sparseKey("one"), []byte("updated"))
+	value, _ = tree.Get(sparseKey("one"))
+	assert.Equal(t, []byte("updated"), value)
+
+	tree.Delete(sparseKey("one"))
+	tree.Delete(sparseKey("three"))
+	_, ok = tree.Get(sparseKey("one"))
+	assert.False(t, ok)
+
+	other := NewSparseMerkleTree(SHA256Hasher)
+	other.Set(sparseKey("two"), []byte("2"))
+	assert.Equal(t, other.Hash(), tree.Hash())
+
+	tree.Delete(sparseKey("two"))
+	assert.Equal(t, empty, tree.Hash())
+	assert.Empty(t, tree.nodes)
+}
+
+func TestSparseMerkleTree_OrderIndependence(t *testing.T) {
+	first := NewSparseMerkleTree(Blake2b256Hasher)
+	second := NewSparseMerkleTree(Blake2b256Hasher)
+	for i := 0; i < 50; i++ {
+		first.Set(sparseKey(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("value %d", i)))
+		second.Set(sparseKey(fmt.Sprintf("%d", 49-i)), []byte(fmt.Sprintf("value %d", 49-i)))
+	}
+	assert.Equal(t, first.Hash(), second.Hash())
+}
+
+func TestSparseMerkleTree_GenerateProof(t *testing.T) {
+	tree := NewSparseMerkleTree(SHA256Hasher)
+	for i := 0; i < 50; i++ {
+		tree.Set(sparseKey(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("value %d", i)))
+	}
+	root := tree.Hash()
+
+	for i := 0; i < 100; i++ {
+		proof := tree.GenerateProof(sparseKey(fmt.Sprintf("%d", i)))
+		assert.Equal(t, i < 50, proof.Exists(), fmt.Sprintf("for key %d", i))
+		assert.Equal(t, sparseKey(fmt.Sprintf("%d", i)), proof.Key())
+		assert.NoError(t, VerifySparseProof(proof, root, SHA256Hasher), fmt.Sprintf("for key %d", i))
+		assert.Less(t, len(proof.siblingHashes), 20, fmt.Sprintf("for key %d", i))
+	}
+}
+
+func TestVerifySparseProof_Errors(t *testing.T) {
+	tree := NewSparseMerkleTree(SHA256Hasher)
+	tree.Set(sparseKey("one"), []byte("1"))
+	tree.Set(sparseKey("two"), []byte("2"))
+	root := tree.Hash()
+	inclusion := tree.GenerateProof(sparseKey("one"))
+	exclusion := tree.GenerateProof(sparseKey("three"))
+	require.True(t, inclusion.Exists())
+	require.False(t, exclusion.Exists())
+
+	forged := *inclusion
+	forged.value = []byte("forged")
+	assert.ErrorIs(t, VerifySparseProof(&forged, root, SHA256Hasher), ErrWrongProof)
+
+	forged = *inclusion
+	forged.exists = false
+	forged.value = nil
+	assert.ErrorIs(t, VerifySparseProof(&forged, root, SHA256Hasher), ErrWrongProof, "present key claimed absent")
+
+	forged = *exclusion
+	forged.exists = true
+	forged.value = []byte("3")
+	assert.ErrorIs(t, VerifySparseProof(&forged, root, SHA256Hasher), ErrWrongProof, "absent key claimed present")
+
+	forged = *exclusion
+	forged.siblingHashes = nil
+	assert.ErrorIs(t, VerifySparseProof(&forged, root, SHA256Hasher), ErrWrongProof)
+
+	forged = *exclusion
+	forged.siblingHashes = append(append([][]byte{}, exclusion.siblingHashes...), root)
+	assert.ErrorIs(t, VerifySparseProof(&forged, root, SHA256Hasher), ErrWrongProof)
+
+	assert.ErrorIs(t, VerifySparseProof(inclusion, root, Blake2b256Hasher), ErrWrongProof)
+
+	tree.Delete(sparseKey("one"))
+	assert.ErrorIs(t, VerifySparseProof(inclusion, tree.Hash(), SHA256Hasher), ErrWrongProof)
+	assert.NoError(t, VerifySparseProof(tree.GenerateProof(sparseKey("one")), tree.Hash(), SHA256Hasher))
+}
+
+func TestSparseProof_Encoding_RoundTrip(t *testing.T) {
+	tree := NewSparseMerkleTree(SHA256Hasher)
+	emptyRoot := tree.Hash()
+	emptyProof := tree.GenerateProof(sparseKey("0"))
+	for i := 0; i < 50; i++ {
+		tree.Set(sparseKey(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("value %d", i)))
+	}
+	root := tree.Hash()
+
+	proofs := map[string]*SparseProof{
+		"inclusion":            tree.GenerateProof(sparseKey("7")),
+		"exclusion":            tree.GenerateProof(sparseKey("77")),
+		"exclusion from empty": emptyProof,
+	}
+	for name, proof := range proofs {
+		t.Run(name, func(t *testing.T) {
+			data, err := proof.MarshalBinary()
+			require.NoError(t, err)
+			decoded := &SparseProof{}
+			require.NoError(t, decoded.UnmarshalBinary(data))
+			assert.Equal(t, proof.Key(), decoded.Key())
+			assert.Equal(t, proof.Value(), decoded.Value())
+			assert.Equal(t, proof.Exists(), decoded.Exists())
+			assert.Equal(t, proof.Bitmap(), decoded.Bitmap())
+			assert.Equal(t, len(proof.SiblingHashes()), len(decoded.SiblingHashes()))
+			reencoded, err := decoded.MarshalBinary()
+			require.NoError(t, err)
+			assert.Equal(t, data, reencoded)
+			expectedRoot := root
+			if proof == emptyProof {
+				expectedRoot = emptyRoot
+			}
+			assert.NoError(t, VerifySparseProof(decoded, expectedRoot, SHA256Hasher))
+		})
+	}
+}
+
+func TestSparseProof_Getters(t *testing.T) {
+	tree := NewSparseMerkleTree(SHA256Hasher)
+	tree.Set(sparseKey("one"), []byte("1"))
+	tree.Set(sparseKey("two"), []byte("2"))
+	proof := tree.GenerateProof(sparseKey("one"))
+
+	rebuilt := NewSparseProof(proof.Key(), proof.Value(), proof.Exists(), proof.Bitmap(), proof.SiblingHashes())
+	assert.Equal(t, proof, rebuilt)
+	assert.NoError(t, VerifySparseProof(rebuilt, tree.Hash(), SHA256Hasher))
+	assert.Len(t, rebuilt.SiblingHashes(), 1)
+}
+
+func TestSparseProof_UnmarshalBinary_Errors(t *testing.T) {
+	var bitmap [sparseDepth / 8]byte
+	bitmap[0] = 1
+	valid, err := NewSparseProof(sparseKey("one"), []byte("1"), true, bitmap, [][]byte{{4, 5, 6}}).MarshalBinary()
+	require.NoError(t, err)
+
+	for i := 0; i < len(valid); i++ {
+		assert.ErrorIs(t, (&SparseProof{}).UnmarshalBinary(valid[:i]), ErrTruncatedProof, fmt.Sprintf("prefix of length %d", i))
+	}
+	assert.ErrorIs(t, (&SparseProof{}).UnmarshalBinary(append([]byte{2}, valid[1:]...)), ErrUnsupportedProofVersion)
+	assert.ErrorIs(t, (&SparseProof{}).UnmarshalBinary(append(append([]byte{}, valid...), 0)), ErrOversizedProof)
+	invalid := append([]byte{}, valid...)
+	invalid[1+len(SparseKey{})] = 2
+	assert.ErrorIs(t, (&SparseProof{}).UnmarshalBinary(invalid), ErrOversizedProof)
+}
@@ -0,0 +1,419 @@
+package merkletree
+
+import "sync"
+
+// NodeID identifies a node in a NodeStore. The zero NodeID refers to no node.
+type NodeID uint64
+
+// StoredNode is a node of a tree kept in a NodeStore.
+//
+// A leaf has neither children. A node without a pair duplicated in the DuplicateOdd mode has only the left child,
+// the node it repeats, and its hash. A leaf paired with itself is the child on both sides of its parent.
+type StoredNode struct {
+	Hash  []byte
+	Left  NodeID
+	Right NodeID
+}
+
+func (sn StoredNode) isLeaf() bool {
+	return sn.Left == 0
+}
+
+func (sn StoredNode) isUnpaired() bool {
+	return sn.Left != 0 && sn.Right == 0
+}
+
+// NodeStore keeps the nodes of trees outside of memory. A tree is written with MerkleTree.Save or a StoreBuilder
+// and read back with LoadMerkleTree or OpenStoredTree. A NodeStore has to be safe for concurrent use.
+type NodeStore interface {
+	// PutNode stores the node and returns its ID. The children of the node are stored before the node itself.
+	PutNode(node StoredNode) (NodeID, error)
+	// GetNode returns the node with the given ID or an error wrapping ErrNodeNotFound.
+	GetNode(id NodeID) (StoredNode, error)
+	// SetRoot records the root of a completely stored tree with leafCount leaves.
+	SetRoot(id NodeID, leafCount int) error
+	// Root returns the root of the last stored tree and its number of leaves, or ErrNoStoredTree.
+	Root() (NodeID, int, error)
+}
+
+// Save writes the nodes of the tree to the store and records its root. Only the hashes
+// of the leaves are stored, not their content. The nodes keep their IDs, so when the tree is saved again
+// to the same store, or to the store it was loaded from, only the nodes which changed since are written
+// and appending a leaf or updating one costs O(log n) writes. Saving the tree to another store writes all the nodes.
+func (mt *MerkleTree) Save(store NodeStore) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	s := &treeSaver{store: store, reuse: mt.savedTo == store}
+	// the IDs of the previous store are overwritten as the nodes are written
+	mt.savedTo = nil
+	id, err := s.save(mt.root)
+	if err != nil {
+		return err
+	}
+	if err := store.SetRoot(id, len(mt.leaves)); err != nil {
+		return err
+	}
+	mt.savedTo = store
+	return nil
+}
+
+type treeSaver struct {
+	store NodeStore
+	// reuse tells whether the IDs kept by the nodes refer to the store
+	reuse bool
+}
+
+func (s *treeSaver) save(n node) (NodeID, error) {
+	switch n := n.(type) {
+	case *Leaf:
+		if s.reuse && n.storedID != 0 {
+			return n.storedID, nil
+		}
+		id, err := s.store.PutNode(StoredNode{Hash: n.Hash()})
+		n.storedID = id
+		return id, err
+	case *nonLeaf:
+		if s.reuse && n.storedID != 0 {
+			return n.storedID, nil
+		}
+		left, err := s.save(n.left)
+		if err != nil {
+			return 0, err
+		}
+		right := left
+		if phantom, ok := n.right.(*nonLeaf); ok && phantom.right == nil {
+			// the node mirroring the left child is written again along with its parent, as it changes with the child
+			right, err = s.store.PutNode(StoredNode{Hash: phantom.Hash(), Left: left})
+		} else if n.right != n.left {
+			right, err = s.save(n.right)
+		}
+		if err != nil {
+			return 0, err
+		}
+		id, err := s.store.PutNode(StoredNode{Hash: n.Hash(), Left: left, Right: right})
+		n.storedID = id
+		return id, err
+	}
+	return 0, nil
+}
+
+// LoadMerkleTree reads the last tree saved to the store into memory. The stored hashes are used as they are,
+// so nothing is hashed again. The leaves only hold their hashes. The hashing function and the options are used
+// when the tree is modified and have to match the ones the tree was built with.
+func LoadMerkleTree(store NodeStore, hasher Hasher, opts ...Option) (*MerkleTree, error) {
+	rootID, leafCount, err := store.Root()
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	h := o.sumHasher(hasher)
+	l := &treeLoader{store: store, leafHasher: o.mode.leafHasher(h), nodeHasher: o.mode.nodeHasher(h)}
+	sn, err := store.GetNode(rootID)
+	if err != nil {
+		return nil, err
+	}
+	root, err := l.load(rootID, sn)
+	if err != nil {
+		return nil, err
+	}
+	if len(l.leaves) != leafCount {
+		return nil, ErrCorruptedStore
+	}
+	mt := &MerkleTree{leaves: l.leaves, hasher: h, opts: o, root: root, savedTo: store}
+	mt.frontier = mt.collectFrontier()
+	mt.buildIndex()
+	return mt, nil
+}
+
+type treeLoader struct {
+	store      NodeStore
+	leafHasher func([]byte) []byte
+	nodeHasher pairHasher
+	leaves     []*Leaf
+}
+
+// load builds the node with the given ID, which was already read from the store as sn, along with its subtree.
+func (l *treeLoader) load(id NodeID, sn StoredNode) (node, error) {
+	if sn.isLeaf() {
+		leaf := newHashLeaf(sn.Hash)
+		leaf.hashFunc = l.leafHasher
+		leaf.storedID = id
+		l.leaves = append(l.leaves, leaf)
+		return leaf, nil
+	}
+	if sn.isUnpaired() {
+		// an unpaired node is only loaded as the right child of its parent
+		return nil, ErrCorruptedStore
+	}
+	leftNode, err := l.store.GetNode(sn.Left)
+	if err != nil {
+		return nil, err
+	}
+	left, err := l.load(sn.Left, leftNode)
+	if err != nil {
+		return nil, err
+	}
+	right := left
+	if sn.Right != sn.Left {
+		rightNode, err := l.store.GetNode(sn.Right)
+		if err != nil {
+			return nil, err
+		}
+		if rightNode.isUnpaired() {
+			right = &nonLeaf{left: left}
+		} else if right, err = l.load(sn.Right, rightNode); err != nil {
+			return nil, err
+		}
+	}
+	nl := newNonLeaf(left, right, l.nodeHasher)
+	nl.cachedHash = sn.Hash
+	nl.storedID = id
+	return nl, nil
+}
+
+// StoredTree is a read-only view of a tree saved to a NodeStore. Only the nodes needed
+// to answer a call are read from the store, so the tree doesn't have to fit in memory.
+type StoredTree struct {
+	store     NodeStore
+	root      StoredNode
+	leafCount int
+}
+
+// OpenStoredTree opens the last tree saved to the store without reading any other node than its root.
+func OpenStoredTree(store NodeStore) (*StoredTree, error) {
+	rootID, leafCount, err := store.Root()
+	if err != nil {
+		return nil, err
+	}
+	root, err := store.GetNode(rootID)
+	if err != nil {
+		return nil, err
+	}
+	return &StoredTree{store: store, root: root, leafCount: leafCount}, nil
+}
+
+// Hash returns the root hash of the stored tree.
+func (st *StoredTree) Hash() []byte {
+	return st.root.Hash
+}
+
+// Len returns the number of leaves of the stored tree.
+func (st *StoredTree) Len() int {
+	return st.leafCount
+}
+
+// GenerateProof creates a proof for the leaf at the provided index, equal to the one created by
+// MerkleTree.GenerateProof for the saved tree. Only the nodes on the path to the leaf and their siblings are read.
+// It returns an error if the index is out of bounds, the leaf was removed or the store fails.
+func (st *StoredTree) GenerateProof(idx int) (*Proof, error) {
+	if idx < 0 || idx >= st.leafCount {
+		return nil, &IndexOutOfRangeError{Index: idx, Size: st.leafCount}
+	}
+	var siblingHashes [][]byte
+	n, lo, size := st.root, 0, st.leafCount
+	for !n.isLeaf() {
+		left, err := st.store.GetNode(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		right := left
+		if n.Right != n.Left {
+			if right, err = st.store.GetNode(n.Right); err != nil {
+				return nil, err
+			}
+		}
+		powerOf2 := nearestSmallerPowerOf2(size)
+		switch {
+		case n.Right == n.Left || right.isUnpaired():
+			// the right child only repeats the left one
+			siblingHashes = append(siblingHashes, right.Hash)
+			n = left
+		case idx < lo+powerOf2:
+			siblingHashes = append(siblingHashes, right.Hash)
+			n, size = left, powerOf2
+		default:
+			siblingHashes = append(siblingHashes, left.Hash)
+			n, lo, size = right, lo+powerOf2, size-powerOf2
+		}
+	}
+	if isTombstone(n.Hash) {
+		return nil, &RemovedLeafError{Index: idx}
+	}
+	for i, j := 0, len(siblingHashes)-1; i < j; i, j = i+1, j-1 {
+		siblingHashes[i], siblingHashes[j] = siblingHashes[j], siblingHashes[i]
+	}
+	return NewProof(idx, n.Hash, siblingHashes), nil
+}
+
+// StoreBuilder builds a tree directly in a NodeStore from leaves appended one by one. Only the roots
+// of the complete subtrees on the right edge of the tree are kept in memory, so the tree doesn't have to fit in memory.
+// The stored tree is the same as the one saved by MerkleTree.Save for the same leaves and options.
+// A StoreBuilder is not safe for concurrent use.
+type StoreBuilder struct {
+	store      NodeStore
+	leafHasher func([]byte) []byte
+	nodeHasher pairHasher
+	promoteOdd bool
+	// frontier holds the roots of the complete subtrees on the right edge of the tree, the largest first.
+	frontier []storedRef
+	size     int
+}
+
+// storedRef refers to a node written to a store.
+type storedRef struct {
+	id   NodeID
+	hash []byte
+}
+
+// NewStoreBuilder creates a StoreBuilder writing to the store with a hashing function and optional settings.
+func NewStoreBuilder(store NodeStore, hasher Hasher, opts ...Option) *StoreBuilder {
+	o := newOptions(opts)
+	h := o.sumHasher(hasher)
+	return &StoreBuilder{
+		store:      store,
+		leafHasher: o.mode.leafHasher(h),
+		nodeHasher: o.mode.nodeHasher(h),
+		promoteOdd: o.mode.promotesOdd(),
+	}
+}
+
+// Len returns the number of leaves appended so far.
+func (b *StoreBuilder) Len() int {
+	return b.size
+}
+
+// Append writes a leaf with the hash of the content to the store, along with the subtrees it completes.
+// Writing a leaf costs O(1) writes on average.
+func (b *StoreBuilder) Append(content []byte) error {
+	hash := b.leafHasher(content)
+	id, err := b.store.PutNode(StoredNode{Hash: hash})
+	if err != nil {
+		return err
+	}
+	b.frontier = append(b.frontier, storedRef{id: id, hash: hash})
+	for size := b.size; size&1 == 1; size >>= 1 {
+		last := len(b.frontier) - 1
+		parent, err := b.pair(b.frontier[last-1], b.frontier[last])
+		if err != nil {
+			return err
+		}
+		b.frontier = append(b.frontier[:last-1], parent)
+	}
+	b.size++
+	return nil
+}
+
+// Finish writes the nodes joining the complete subtrees into the root, records the root in the store
+// and returns the stored tree. More leaves can be appended afterwards and Finish called again to store a larger tree.
+// It returns ErrEmptyTree if no leaf was appended.
+func (b *StoreBuilder) Finish() (*StoredTree, error) {
+	if b.size == 0 {
+		return nil, ErrEmptyTree
+	}
+	// the subtrees are joined from the smallest one like in rootFromFrontier
+	next := len(b.frontier) - 1
+	var root storedRef
+	rootHeight := -1
+	for height := 0; b.size>>height > 0; height++ {
+		if (b.size>>height)&1 == 0 {
+			continue
+		}
+		peak := b.frontier[next]
+		next--
+		if rootHeight < 0 {
+			root, rootHeight = peak, height
+			continue
+		}
+		var err error
+		for ; !b.promoteOdd && rootHeight < height; rootHeight++ {
+			if root, err = b.unpaired(root, rootHeight); err != nil {
+				return nil, err
+			}
+		}
+		if root, err = b.pair(peak, root); err != nil {
+			return nil, err
+		}
+		rootHeight = height + 1
+	}
+	if rootHeight == 0 && !b.promoteOdd {
+		var err error
+		if root, err = b.unpaired(root, rootHeight); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.store.SetRoot(root.id, b.size); err != nil {
+		return nil, err
+	}
+	return OpenStoredTree(b.store)
+}
+
+// pair writes the parent of two nodes.
+func (b *StoreBuilder) pair(left, right storedRef) (storedRef, error) {
+	hash := b.nodeHasher(left.hash, right.hash)
+	id, err := b.store.PutNode(StoredNode{Hash: hash, Left: left.id, Right: right.id})
+	return storedRef{id: id, hash: hash}, err
+}
+
+// unpaired writes the parent of a node without a pair in the DuplicateOdd mode, see newUnpairedNonLeaf.
+func (b *StoreBuilder) unpaired(n storedRef, height int) (storedRef, error) {
+	if height == 0 {
+		return b.pair(n, n)
+	}
+	id, err := b.store.PutNode(StoredNode{Hash: n.hash, Left: n.id})
+	if err != nil {
+		return storedRef{}, err
+	}
+	return b.pair(n, storedRef{id: id, hash: n.hash})
+}
+
+// MemoryNodeStore is a NodeStore keeping the nodes in memory.
+type MemoryNodeStore struct {
+	mu        sync.RWMutex
+	nodes     []StoredNode
+	root      NodeID
+	leafCount int
+}
+
+// NewMemoryNodeStore creates an empty MemoryNodeStore.
+func NewMemoryNodeStore() *MemoryNodeStore {
+	return &MemoryNodeStore{}
+}
+
+// PutNode stores the node and returns its ID.
+func (s *MemoryNodeStore) PutNode(node StoredNode) (NodeID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes = append(s.nodes, node)
+	return NodeID(len(s.nodes)), nil
+}
+
+// GetNode returns the node with the given ID.
+func (s *MemoryNodeStore) GetNode(id NodeID) (StoredNode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if id == 0 || id > NodeID(len(s.nodes)) {
+		return StoredNode{}, ErrNodeNotFound
+	}
+	return s.nodes[id-1], nil
+}
+
+// SetRoot records the root of a stored tree.
+func (s *MemoryNodeStore) SetRoot(id NodeID, leafCount int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == 0 || id > NodeID(len(s.nodes)) {
+		return ErrNodeNotFound
+	}
+	s.root, s.leafCount = id, leafCount
+	return nil
+}
+
+// Root returns the root of the last stored tree.
+func (s *MemoryNodeStore) Root() (NodeID, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.root == 0 {
+		return 0, 0, ErrNoStoredTree
+	}
+	return s.root, s.leafCount, nil
+}
@@ -0,0 +1,342 @@
+package merkletree
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleTree_Save(t *testing.T) {
+	stores := map[string]func(t *testing.T) NodeStore{
+		"memory": func(t *testing.T) NodeStore {
+			return NewMemoryNodeStore()
+		},
+		"file": func(t *testing.T) NodeStore {
+			store, err := OpenFileNodeStore(t.TempDir())
+			require.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	for storeName, newStore := range stores {
+		for name, mode := range testModes {
+			for _, size := range []int{1, 2, 3, 6, 8, 13} {
+				t.Run(fmt.Sprintf("%s %s %d leaves", storeName, name, size), func(t *testing.T) {
+					contents := make([][]byte, size)
+					for i := range contents {
+						contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+					}
+					tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+					require.NoError(t, err)
+					store := newStore(t)
+					require.NoError(t, tree.Save(store))
+
+					stored, err := OpenStoredTree(store)
+					require.NoError(t, err)
+					assert.Equal(t, tree.Hash(), stored.Hash())
+					assert.Equal(t, size, stored.Len())
+
+					loaded, err := LoadMerkleTree(store, SHA256Hasher, WithMode(mode))
+					require.NoError(t, err)
+					assert.Equal(t, tree.Hash(), loaded.Hash())
+					assert.Equal(t, size, loaded.Len())
+
+					for i := range contents {
+						expected, err := tree.GenerateProof(i)
+						require.NoError(t, err)
+						proof, err := stored.GenerateProof(i)
+						require.NoError(t, err)
+						assert.True(t, expected.Equal(proof))
+						proof, err = loaded.GenerateProof(i)
+						require.NoError(t, err)
+						assert.True(t, expected.Equal(proof))
+					}
+					_, err = stored.GenerateProof(size)
+					assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+
+					// a loaded tree can be modified like the original one
+					tree.Append(NewLeaf([]byte("appended")))
+					loaded.Append(NewLeaf([]byte("appended")))
+					require.NoError(t, tree.Update(0, []byte("updated")))
+					require.NoError(t, loaded.Update(0, []byte("updated")))
+					assert.Equal(t, tree.Hash(), loaded.Hash())
+				})
+			}
+		}
+	}
+}
+
+func TestMerkleTree_Save_Incremental(t *testing.T) {
+	contents := make([][]byte, 1000)
+	for i := range contents {
+		contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher)
+	require.NoError(t, err)
+	store := NewMemoryNodeStore()
+	require.NoError(t, tree.Save(store))
+	written := len(store.nodes)
+
+	// saving an unchanged tree only records the root again
+	require.NoError(t, tree.Save(store))
+	assert.Equal(t, written, len(store.nodes))
+
+	// only the path of the updated leaf is written, along with the nodes mirroring their left children
+	require.NoError(t, tree.Update(500, []byte("updated")))
+	require.NoError(t, tree.Save(store))
+	assert.LessOrEqual(t, len(store.nodes)-written, 2*11)
+	written = len(store.nodes)
+
+	tree.Append(NewLeaf([]byte("appended")))
+	require.NoError(t, tree.Save(store))
+	assert.LessOrEqual(t, len(store.nodes)-written, 2*11)
+	written = len(store.nodes)
+
+	loaded, err := LoadMerkleTree(store, SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), loaded.Hash())
+	require.NoError(t, loaded.Remove(3))
+	require.NoError(t, loaded.Save(store))
+	assert.LessOrEqual(t, len(store.nodes)-written, 2*11)
+
+	require.NoError(t, tree.Remove(3))
+	stored, err := OpenStoredTree(store)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), stored.Hash())
+	for _, idx := range []int{0, 500, 1000} {
+		expected, err := tree.GenerateProof(idx)
+		require.NoError(t, err)
+		proof, err := stored.GenerateProof(idx)
+		require.NoError(t, err)
+		assert.True(t, expected.Equal(proof))
+	}
+
+	// another store gets all the nodes
+	other := NewMemoryNodeStore()
+	require.NoError(t, tree.Save(other))
+	assert.Greater(t, len(other.nodes), 2000)
+	require.NoError(t, tree.Truncate(700))
+	require.NoError(t, tree.Save(other))
+	stored, err = OpenStoredTree(other)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), stored.Hash())
+	assert.Equal(t, 700, stored.Len())
+}
+
+func TestStoreBuilder(t *testing.T) {
+	for name, mode := range testModes {
+		for _, size := range []int{1, 2, 3, 6, 8, 13, 100} {
+			t.Run(fmt.Sprintf("%s %d leaves", name, size), func(t *testing.T) {
+				contents := make([][]byte, size)
+				for i := range contents {
+					contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+				}
+				tree, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+
+				store := NewMemoryNodeStore()
+				builder := NewStoreBuilder(store, SHA256Hasher, WithMode(mode))
+				for _, content := range contents {
+					require.NoError(t, builder.Append(content))
+				}
+				assert.Equal(t, size, builder.Len())
+				stored, err := builder.Finish()
+				require.NoError(t, err)
+				assert.Equal(t, tree.Hash(), stored.Hash())
+				assert.Equal(t, size, stored.Len())
+				for i := range contents {
+					expected, err := tree.GenerateProof(i)
+					require.NoError(t, err)
+					proof, err := stored.GenerateProof(i)
+					require.NoError(t, err)
+					assert.True(t, expected.Equal(proof))
+				}
+
+				loaded, err := LoadMerkleTree(store, SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				assert.Equal(t, tree.Hash(), loaded.Hash())
+
+				// the builder goes on after storing a tree
+				tree.Append(NewLeaf([]byte("appended")))
+				require.NoError(t, builder.Append([]byte("appended")))
+				stored, err = builder.Finish()
+				require.NoError(t, err)
+				assert.Equal(t, tree.Hash(), stored.Hash())
+			})
+		}
+	}
+
+	_, err := NewStoreBuilder(NewMemoryNodeStore(), SHA256Hasher).Finish()
+	assert.ErrorIs(t, err, ErrEmptyTree)
+}
+
+func TestFileNodeStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileNodeStore(dir)
+	require.NoError(t, err)
+	_, _, err = store.Root()
+	assert.ErrorIs(t, err, ErrNoStoredTree)
+
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	require.NoError(t, tree.Save(store))
+	// nodes of a tree which is never completed are discarded on reopening
+	_, err = store.PutNode(StoredNode{Hash: []byte("incomplete")})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+	index, err := os.OpenFile(filepath.Join(dir, fileStoreIndexName), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = index.Write([]byte{1, 2, 3})
+	require.NoError(t, err)
+	require.NoError(t, index.Close())
+
+	store, err = OpenFileNodeStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	stored, err := OpenStoredTree(store)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), stored.Hash())
+	proof, err := stored.GenerateProof(2)
+	require.NoError(t, err)
+	assert.NoError(t, tree.VerifyProof(proof))
+
+	// the store keeps accepting nodes after the last complete one
+	other, err := NewMerkleTree(newLeaves([][]byte{[]byte("four")}), SHA256Hasher)
+	require.NoError(t, err)
+	require.NoError(t, other.Save(store))
+	loaded, err := LoadMerkleTree(store, SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, other.Hash(), loaded.Hash())
+}
+
+func TestFileNodeStore_Reopen_TruncatedLog(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileNodeStore(dir)
+	require.NoError(t, err)
+	tree, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	require.NoError(t, tree.Save(store))
+	_, _, err = store.Root()
+	require.NoError(t, err)
+	count := store.count
+	for _, hash := range []string{"first", "second", "third"} {
+		_, err = store.PutNode(StoredNode{Hash: []byte(hash)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, store.Close())
+
+	// the index survived a crash while the records of the last two nodes didn't reach the log completely
+	logName := filepath.Join(dir, fileStoreLogName)
+	info, err := os.Stat(logName)
+	require.NoError(t, err)
+	truncated := info.Size() - int64(len("third")) - 5
+	require.NoError(t, os.Truncate(logName, truncated))
+
+	store, err = OpenFileNodeStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+	assert.Equal(t, count+1, store.count)
+	info, err = os.Stat(logName)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), truncated)
+	node, err := store.GetNode(NodeID(count + 1))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), node.Hash)
+	_, err = store.GetNode(NodeID(count + 2))
+	assert.ErrorIs(t, err, ErrNodeNotFound)
+
+	// the dropped nodes are written again after the last complete one
+	id, err := store.PutNode(StoredNode{Hash: []byte("second")})
+	require.NoError(t, err)
+	assert.Equal(t, NodeID(count+2), id)
+	node, err = store.GetNode(id)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), node.Hash)
+	loaded, err := LoadMerkleTree(store, SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, tree.Hash(), loaded.Hash())
+}
+
+func TestFileNodeStore_Errors(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFileNodeStore(dir)
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.GetNode(1)
+	assert.ErrorIs(t, err, ErrNodeNotFound)
+	assert.ErrorIs(t, store.SetRoot(1, 1), ErrNodeNotFound)
+	_, err = OpenStoredTree(store)
+	assert.ErrorIs(t, err, ErrNoStoredTree)
+	_, err = LoadMerkleTree(store, SHA256Hasher)
+	assert.ErrorIs(t, err, ErrNoStoredTree)
+
+	id, err := store.PutNode(StoredNode{Hash: []byte("leaf")})
+	require.NoError(t, err)
+	require.NoError(t, store.SetRoot(id, 2))
+	_, err = LoadMerkleTree(store, SHA256Hasher)
+	assert.ErrorIs(t, err, ErrCorruptedStore)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fileStoreRootName), []byte("XXXX"), 0o644))
+	_, _, err = store.Root()
+	assert.ErrorIs(t, err, ErrCorruptedStore)
+}
+
+// buildStoredTree builds a tree of size random leaves directly in a FileNodeStore in dir with a StoreBuilder,
+// so the tree is never kept in memory.
+func buildStoredTree(b *testing.B, dir string, size int) {
+	store, err := OpenFileNodeStore(dir)
+	require.NoError(b, err)
+	defer store.Close()
+	builder := NewStoreBuilder(store, SHA256Hasher)
+	rnd := rand.New(rand.NewSource(1))
+	leaf := make([]byte, 32)
+	for i := 0; i < size; i++ {
+		rnd.Read(leaf)
+		require.NoError(b, builder.Append(leaf))
+	}
+	_, err = builder.Finish()
+	require.NoError(b, err)
+}
+
+// BenchmarkStoreBuilder builds trees directly on disk. The memory used only depends on the height of the tree.
+func BenchmarkStoreBuilder(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 16} {
+		b.Run(fmt.Sprintf("%d leaves", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buildStoredTree(b, b.TempDir(), size)
+			}
+		})
+	}
+}
+
+// BenchmarkStoredTree_GenerateProof generates proofs for trees which are only kept on disk. Neither building
+// the trees nor generating the proofs keeps the trees in memory, so it works the same way for trees larger
+// than the available memory.
+func BenchmarkStoredTree_GenerateProof(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 20} {
+		dir := b.TempDir()
+		buildStoredTree(b, dir, size)
+
+		b.Run(fmt.Sprintf("%d leaves", size), func(b *testing.B) {
+			store, err := OpenFileNodeStore(dir)
+			require.NoError(b, err)
+			defer store.Close()
+			stored, err := OpenStoredTree(store)
+			require.NoError(b, err)
+			rnd := rand.New(rand.NewSource(2))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := stored.GenerateProof(rnd.Intn(size))
+				require.NoError(b, err)
+			}
+		})
+	}
+}
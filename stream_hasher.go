@@ -0,0 +1,182 @@
+package merkletree
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// StreamHasher hashes data written to a hash.Hash in several parts, so the hashes of the children
+// of an inner node are hashed without being concatenated and leaves can be hashed straight from an io.Reader.
+// The hash.Hash states are pooled and reused. A StreamHasher is safe for concurrent use.
+type StreamHasher struct {
+	pool sync.Pool
+}
+
+// NewStreamHasher creates a StreamHasher using hash states returned by newHash, e.g. sha256.New.
+func NewStreamHasher(newHash func() hash.Hash) *StreamHasher {
+	s := &StreamHasher{}
+	s.pool.New = func() any {
+		return newHash()
+	}
+	return s
+}
+
+// NewStreamHasherFromHasher adapts a Hasher to a StreamHasher. The written parts are buffered
+// and passed to the hasher at once, so the result is the same as the one of the hasher.
+func NewStreamHasherFromHasher(hasher Hasher) *StreamHasher {
+	return NewStreamHasher(func() hash.Hash {
+		return &bufferedHash{hasher: hasher}
+	})
+}
+
+var (
+
+	// SHA256StreamHasher is a StreamHasher that implements the SHA-256 hash algorithm.
+	SHA256StreamHasher = NewStreamHasher(sha256.New)
+
+	// SHA512StreamHasher is a StreamHasher that implements the SHA-512 hash algorithm.
+	SHA512StreamHasher = NewStreamHasher(sha512.New)
+
+	// Blake2b256StreamHasher is a StreamHasher that implements the Blake2b-256 hash algorithm.
+	Blake2b256StreamHasher = NewStreamHasher(func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	})
+
+	// Blake2b512StreamHasher is a StreamHasher that implements the Blake2b-512 hash algorithm.
+	Blake2b512StreamHasher = NewStreamHasher(func() hash.Hash {
+		h, _ := blake2b.New512(nil)
+		return h
+	})
+
+	// Keccak256StreamHasher is a StreamHasher that implements the Keccak-256 hash algorithm used by Ethereum.
+	Keccak256StreamHasher = NewStreamHasher(sha3.NewLegacyKeccak256)
+
+	// SHA3_256StreamHasher is a StreamHasher that implements the SHA3-256 hash algorithm.
+	SHA3_256StreamHasher = NewStreamHasher(sha3.New256)
+
+	// SHA3_512StreamHasher is a StreamHasher that implements the SHA3-512 hash algorithm.
+	SHA3_512StreamHasher = NewStreamHasher(sha3.New512)
+
+	// MD5StreamHasher is a StreamHasher that implements the MD5 hash algorithm.
+	MD5StreamHasher = NewStreamHasher(md5.New)
+)
+
+// Sum returns the hash of the concatenation of the parts.
+func (s *StreamHasher) Sum(parts ...[]byte) []byte {
+	h := s.pool.Get().(hash.Hash)
+	defer s.put(h)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// SumReader returns the hash of everything read from r until io.EOF.
+func (s *StreamHasher) SumReader(r io.Reader) ([]byte, error) {
+	h := s.pool.Get().(hash.Hash)
+	defer s.put(h)
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Hasher returns a Hasher producing the same hashes as the StreamHasher, e.g. to verify proofs with functions taking a Hasher.
+func (s *StreamHasher) Hasher() Hasher {
+	return func(data []byte) []byte {
+		return s.Sum(data)
+	}
+}
+
+func (s *StreamHasher) put(h hash.Hash) {
+	h.Reset()
+	s.pool.Put(h)
+}
+
+func (s *StreamHasher) sum(parts ...[]byte) []byte {
+	return s.Sum(parts...)
+}
+
+// sumHasher is implemented by Hasher and StreamHasher. It hashes the concatenation of the parts.
+type sumHasher interface {
+	sum(parts ...[]byte) []byte
+}
+
+func (h Hasher) sum(parts ...[]byte) []byte {
+	if len(parts) == 1 {
+		return h(parts[0])
+	}
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	data := make([]byte, 0, size)
+	for _, part := range parts {
+		data = append(data, part...)
+	}
+	return h(data)
+}
+
+// HashLeafFromReader returns the hash of a leaf whose content is read from r until io.EOF,
+// without holding the content in memory. The hash is the same as the one of a leaf with
+// the same content in a tree built with the given options.
+func HashLeafFromReader(r io.Reader, hasher *StreamHasher, opts ...Option) ([]byte, error) {
+	switch newOptions(opts).mode {
+	case RFC6962:
+		return hasher.SumReader(io.MultiReader(bytes.NewReader(rfc6962LeafPrefixBytes), r))
+	case SortedPair:
+		hash, err := hasher.SumReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return hasher.Sum(hash), nil
+	}
+	return hasher.SumReader(r)
+}
+
+// NewLeafFromReader creates a leaf whose content is read from r until io.EOF, like HashLeafFromReader.
+// The leaf only holds the hash of its content, so it can be added to a tree built with the same hashing function
+// and options without the content being held in memory. Proofs can be generated for it like for any other leaf.
+func NewLeafFromReader(r io.Reader, hasher *StreamHasher, opts ...Option) (*Leaf, error) {
+	hash, err := HashLeafFromReader(r, hasher, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newHashLeaf(hash), nil
+}
+
+// bufferedHash is a hash.Hash collecting the written data for a Hasher.
+type bufferedHash struct {
+	hasher Hasher
+	buf    []byte
+}
+
+func (b *bufferedHash) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *bufferedHash) Sum(in []byte) []byte {
+	return append(in, b.hasher(b.buf)...)
+}
+
+func (b *bufferedHash) Reset() {
+	b.buf = b.buf[:0]
+}
+
+func (b *bufferedHash) Size() int {
+	return len(b.hasher(nil))
+}
+
+func (b *bufferedHash) BlockSize() int {
+	return 1
+}
@@ -0,0 +1,146 @@
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamHasher_Sum(t *testing.T) {
+	hashers := []struct {
+		name   string
+		stream *StreamHasher
+		hasher Hasher
+	}{
+		{"sha256", SHA256StreamHasher, SHA256Hasher},
+		{"sha512", SHA512StreamHasher, SHA512Hasher},
+		{"blake2b256", Blake2b256StreamHasher, Blake2b256Hasher},
+		{"blake2b512", Blake2b512StreamHasher, Blake2b512Hasher},
+		{"keccak256", Keccak256StreamHasher, Keccak256Hasher},
+		{"sha3-256", SHA3_256StreamHasher, SHA3_256Hasher},
+		{"sha3-512", SHA3_512StreamHasher, SHA3_512Hasher},
+		{"md5", MD5StreamHasher, MD5Hasher},
+		{"adapter", NewStreamHasherFromHasher(SHA256Hasher), SHA256Hasher},
+	}
+	for _, h := range hashers {
+		t.Run(h.name, func(t *testing.T) {
+			assert.Equal(t, h.hasher([]byte("onetwothree")), h.stream.Sum([]byte("one"), []byte("two"), []byte("three")))
+			// the pooled state is reset between calls
+			assert.Equal(t, h.hasher([]byte("one")), h.stream.Sum([]byte("one")))
+			assert.Equal(t, h.hasher(nil), h.stream.Sum())
+			assert.Equal(t, h.hasher([]byte("four")), h.stream.Hasher()([]byte("four")))
+
+			hash, err := h.stream.SumReader(iotest.OneByteReader(strings.NewReader("onetwothree")))
+			require.NoError(t, err)
+			assert.Equal(t, h.hasher([]byte("onetwothree")), hash)
+		})
+	}
+}
+
+func TestStreamHasher_SumReader_Error(t *testing.T) {
+	readErr := errors.New("read failed")
+	_, err := SHA256StreamHasher.SumReader(iotest.ErrReader(readErr))
+	assert.ErrorIs(t, err, readErr)
+	_, err = HashLeafFromReader(iotest.ErrReader(readErr), SHA256StreamHasher, WithMode(SortedPair))
+	assert.ErrorIs(t, err, readErr)
+	_, err = NewLeafFromReader(iotest.ErrReader(readErr), SHA256StreamHasher)
+	assert.ErrorIs(t, err, readErr)
+}
+
+func TestNewLeafFromReader(t *testing.T) {
+	for name, mode := range testModes {
+		t.Run(name, func(t *testing.T) {
+			contents := [][]byte{[]byte("one"), []byte("two"), []byte("three"), []byte("four"), []byte("five")}
+			expected, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+			require.NoError(t, err)
+
+			leaves := make([]*Leaf, len(contents))
+			for i, content := range contents {
+				leaves[i], err = NewLeafFromReader(bytes.NewReader(content), SHA256StreamHasher, WithMode(mode))
+				require.NoError(t, err)
+			}
+			tree, err := NewMerkleTree(leaves[:3], SHA256Hasher, WithMode(mode))
+			require.NoError(t, err)
+			tree.Append(leaves[3:]...)
+			assert.Equal(t, expected.Hash(), tree.Hash())
+
+			proof, err := tree.GenerateProof(4)
+			require.NoError(t, err)
+			assert.NoError(t, expected.VerifyProof(proof))
+		})
+	}
+}
+
+func TestNewMerkleTree_WithStreamHasher(t *testing.T) {
+	for name, mode := range testModes {
+		for _, size := range []int{1, 2, 5, 8, 13} {
+			t.Run(fmt.Sprintf("%s %d leaves", name, size), func(t *testing.T) {
+				contents := make([][]byte, size)
+				for i := range contents {
+					contents[i] = []byte(fmt.Sprintf("leaf %d", i))
+				}
+				expected, err := NewMerkleTree(newLeaves(contents), SHA256Hasher, WithMode(mode))
+				require.NoError(t, err)
+				tree, err := NewMerkleTree(newLeaves(contents[:1]), nil, WithMode(mode), WithStreamHasher(SHA256StreamHasher))
+				require.NoError(t, err)
+				tree.Append(newLeaves(contents[1:])...)
+				assert.Equal(t, expected.Hash(), tree.Hash())
+
+				for i, content := range contents {
+					leafHash, err := HashLeafFromReader(bytes.NewReader(content), SHA256StreamHasher, WithMode(mode))
+					require.NoError(t, err)
+					assert.Equal(t, expected.leaves[i].Hash(), leafHash)
+
+					proof, err := tree.GenerateProof(i)
+					require.NoError(t, err)
+					assert.NoError(t, tree.VerifyProof(proof))
+					assert.NoError(t, VerifyProof(proof, tree.Hash(), nil, size, WithMode(mode), WithStreamHasher(SHA256StreamHasher)))
+					assert.NoError(t, VerifyProof(proof, tree.Hash(), SHA256Hasher, size, WithMode(mode)))
+				}
+			})
+		}
+	}
+}
+
+func newLeaves(contents [][]byte) []*Leaf {
+	leaves := make([]*Leaf, len(contents))
+	for i, content := range contents {
+		leaves[i] = NewLeaf(content)
+	}
+	return leaves
+}
+
+func BenchmarkNewMerkleTree_StreamHasher(b *testing.B) {
+	leaves := make([]*Leaf, 100_000)
+	for i := range leaves {
+		leaves[i] = NewLeaf([]byte(fmt.Sprintf("%d", i)))
+	}
+	benchmarks := []struct {
+		name   string
+		hasher Hasher
+		opts   []Option
+	}{
+		{"hasher", SHA256Hasher, nil},
+		{"stream hasher", nil, []Option{WithStreamHasher(SHA256StreamHasher)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for _, l := range leaves {
+					l.cachedHash = nil
+				}
+				b.StartTimer()
+				_, err := NewMerkleTree(leaves, bm.hasher, bm.opts...)
+				require.NoError(b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Encoder is a function type that encodes a value into the content of a leaf. The encoding has to be canonical,
+// i.e. equal values are always encoded into the same bytes, or trees with the same values would have different hashes.
+type Encoder[T any] func(value T) ([]byte, error)
+
+// StringEncoder is an Encoder using the bytes of a string as the content of the leaf.
+var StringEncoder Encoder[string] = func(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+// Integer is a constraint permitting any integer type.
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// IntegerEncoder returns an Encoder encoding integers as 8 bytes in big-endian order, so the encoding
+// doesn't depend on the platform. Negative numbers are encoded in two's complement.
+func IntegerEncoder[T Integer]() Encoder[T] {
+	return func(value T) ([]byte, error) {
+		return binary.BigEndian.AppendUint64(nil, uint64(value)), nil
+	}
+}
+
+// JSONEncoder returns an Encoder encoding values with encoding/json. The encoding is canonical for values
+// of the same type, as the fields of structs are encoded in the order of their declaration and the keys of maps are sorted.
+// Changing the declaration of a type changes the hashes of the leaves.
+func JSONEncoder[T any]() Encoder[T] {
+	return func(value T) ([]byte, error) {
+		return json.Marshal(value)
+	}
+}
+
+// BinaryEncoder returns an Encoder encoding fixed-size values, like numbers, booleans and structs or arrays of them,
+// with encoding/binary in big-endian order. It returns an error for values that are not fixed-size.
+func BinaryEncoder[T any]() Encoder[T] {
+	return func(value T) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.BigEndian, value); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// TypedTree is a Merkle tree of values of type T, which are encoded into the contents of the leaves by an Encoder.
+// It keeps the values along with the tree, so proofs are returned together with the proven values.
+// A TypedTree is safe for concurrent use like MerkleTree.
+type TypedTree[T any] struct {
+	mu      sync.RWMutex
+	tree    *MerkleTree
+	values  []T
+	encoder Encoder[T]
+}
+
+// NewTypedTree creates a new Merkle tree of the values encoded with the encoder, a hashing function and optional settings.
+// It returns an error if there are no values or a value can't be encoded.
+func NewTypedTree[T any](values []T, encoder Encoder[T], hasher Hasher, opts ...Option) (*TypedTree[T], error) {
+	leaves, err := encodeLeaves(values, encoder)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := NewMerkleTree(leaves, hasher, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedTree[T]{tree: tree, values: append([]T(nil), values...), encoder: encoder}, nil
+}
+
+func encodeLeaves[T any](values []T, encoder Encoder[T]) ([]*Leaf, error) {
+	leaves := make([]*Leaf, len(values))
+	for i, value := range values {
+		content, err := encoder(value)
+		if err != nil {
+			return nil, fmt.Errorf("encoding value %d: %w", i, err)
+		}
+		leaves[i] = NewLeaf(content)
+	}
+	return leaves, nil
+}
+
+// Hash returns the root hash of the tree.
+func (tt *TypedTree[T]) Hash() []byte {
+	return tt.tree.Hash()
+}
+
+// Len returns the number of values in the tree.
+func (tt *TypedTree[T]) Len() int {
+	return tt.tree.Len()
+}
+
+// Value returns the value at the provided index. It returns an error if the index is out of bounds.
+func (tt *TypedTree[T]) Value(idx int) (T, error) {
+	tt.mu.RLock()
+	defer tt.mu.RUnlock()
+	if idx < 0 || idx >= len(tt.values) {
+		var zero T
+		return zero, &IndexOutOfRangeError{Index: idx, Size: len(tt.values)}
+	}
+	return tt.values[idx], nil
+}
+
+// GenerateProof returns the value at the provided index and a proof for it.
+// It returns an error if the index is out of bounds.
+func (tt *TypedTree[T]) GenerateProof(idx int) (T, *Proof, error) {
+	tt.mu.RLock()
+	defer tt.mu.RUnlock()
+	var zero T
+	proof, err := tt.tree.GenerateProof(idx)
+	if err != nil {
+		return zero, nil, err
+	}
+	return tt.values[idx], proof, nil
+}
+
+// VerifyProof checks that the proof proves the value in the tree.
+// It returns an error if the value doesn't match the leaf of the proof or the proof is invalid.
+func (tt *TypedTree[T]) VerifyProof(value T, proof *Proof) error {
+	leafHasher := tt.tree.opts.mode.leafHasher(tt.tree.hasher)
+	if err := checkLeafContent(leafHasher, tt.encoder, value, proof); err != nil {
+		return err
+	}
+	return tt.tree.VerifyProof(proof)
+}
+
+// Append adds new values to the tree. It returns an error without modifying the tree if a value can't be encoded.
+func (tt *TypedTree[T]) Append(values ...T) error {
+	leaves, err := encodeLeaves(values, tt.encoder)
+	if err != nil {
+		return err
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.tree.Append(leaves...)
+	tt.values = append(tt.values, values...)
+	return nil
+}
+
+// Update replaces the value at the provided index.
+// It returns an error if the index is out of bounds or the value can't be encoded.
+func (tt *TypedTree[T]) Update(idx int, value T) error {
+	content, err := tt.encoder(value)
+	if err != nil {
+		return err
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if err := tt.tree.Update(idx, content); err != nil {
+		return err
+	}
+	tt.values[idx] = value
+	return nil
+}
+
+// VerifyTypedProof checks the proof of the value against the root hash of a tree with leafCount values, encoded
+// with the encoder and built with the given hashing function, without the tree itself. The options have to match
+// the ones the tree was built with. It returns an error if the value doesn't match the leaf of the proof.
+func VerifyTypedProof[T any](value T, proof *Proof, root []byte, encoder Encoder[T], hasher Hasher, leafCount int, opts ...Option) error {
+	o := newOptions(opts)
+	h := o.sumHasher(hasher)
+	if err := checkLeafContent(o.mode.leafHasher(h), encoder, value, proof); err != nil {
+		return err
+	}
+	return verifyProof(proof, root, h, leafCount, o)
+}
+
+// checkLeafContent compares the hash of the encoded value with the leaf hash of the proof.
+func checkLeafContent[T any](leafHasher func([]byte) []byte, encoder Encoder[T], value T, proof *Proof) error {
+	content, err := encoder(value)
+	if err != nil {
+		return err
+	}
+	if hash := leafHasher(content); !bytes.Equal(hash, proof.leafHash) {
+		return &LeafHashMismatchError{Index: proof.leafIndex, Provided: proof.leafHash, Expected: hash}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package merkletree
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type account struct {
+	ID      uint32
+	Balance int64
+	Frozen  bool
+}
+
+func TestEncoders(t *testing.T) {
+	content, err := StringEncoder("hello")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), content)
+
+	content, err = IntegerEncoder[int]()(-2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, content)
+	content, err = IntegerEncoder[uint16]()(258)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 1, 2}, content)
+
+	content, err = JSONEncoder[map[string]int]()(map[string]int{"b": 2, "a": 1})
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1,"b":2}`, string(content))
+
+	content, err = BinaryEncoder[account]()(account{ID: 1, Balance: -1, Frozen: true})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 1}, content)
+	_, err = BinaryEncoder[string]()("not fixed-size")
+	assert.Error(t, err)
+}
+
+func TestTypedTree(t *testing.T) {
+	accounts := []account{{1, 100, false}, {2, 250, false}, {3, 0, true}}
+	tree, err := NewTypedTree(accounts, BinaryEncoder[account](), SHA256Hasher, WithMode(RFC6962))
+	require.NoError(t, err)
+
+	leaves := make([]*Leaf, len(accounts))
+	for i, a := range accounts {
+		content := binary.BigEndian.AppendUint32(nil, a.ID)
+		content = binary.BigEndian.AppendUint64(content, uint64(a.Balance))
+		content = append(content, 0)
+		if a.Frozen {
+			content[len(content)-1] = 1
+		}
+		leaves[i] = NewLeaf(content)
+	}
+	expected, err := NewMerkleTree(leaves, SHA256Hasher, WithMode(RFC6962))
+	require.NoError(t, err)
+	assert.Equal(t, expected.Hash(), tree.Hash())
+	assert.Equal(t, 3, tree.Len())
+
+	value, proof, err := tree.GenerateProof(1)
+	require.NoError(t, err)
+	assert.Equal(t, accounts[1], value)
+	assert.NoError(t, tree.VerifyProof(value, proof))
+	assert.NoError(t, VerifyTypedProof(value, proof, tree.Hash(), BinaryEncoder[account](), SHA256Hasher, 3, WithMode(RFC6962)))
+	forged := account{2, 1000, false}
+	assert.ErrorIs(t, tree.VerifyProof(forged, proof), ErrLeafHashMismatch)
+	assert.ErrorIs(t, VerifyTypedProof(forged, proof, tree.Hash(), BinaryEncoder[account](), SHA256Hasher, 3, WithMode(RFC6962)), ErrLeafHashMismatch)
+
+	require.NoError(t, tree.Append(account{4, 10, false}))
+	require.NoError(t, tree.Update(1, forged))
+	value, err = tree.Value(1)
+	require.NoError(t, err)
+	assert.Equal(t, forged, value)
+	value, proof, err = tree.GenerateProof(3)
+	require.NoError(t, err)
+	assert.Equal(t, account{4, 10, false}, value)
+	assert.NoError(t, tree.VerifyProof(value, proof))
+
+	_, _, err = tree.GenerateProof(4)
+	assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+	_, err = tree.Value(-1)
+	assert.ErrorIs(t, err, ErrLeafIndexOutOfBound)
+	assert.ErrorIs(t, tree.Update(4, forged), ErrLeafIndexOutOfBound)
+}
+
+func TestTypedTree_Encoders(t *testing.T) {
+	strings, err := NewTypedTree([]string{"one", "two", "three"}, StringEncoder, SHA256Hasher)
+	require.NoError(t, err)
+	expected, err := NewMerkleTree(newLeaves([][]byte{[]byte("one"), []byte("two"), []byte("three")}), SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Hash(), strings.Hash())
+
+	integers, err := NewTypedTree([]int64{1, -1, 1 << 40}, IntegerEncoder[int64](), Keccak256Hasher)
+	require.NoError(t, err)
+	value, proof, err := integers.GenerateProof(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<40), value)
+	assert.NoError(t, integers.VerifyProof(value, proof))
+
+	type item struct {
+		Name  string   `json:"name"`
+		Tags  []string `json:"tags"`
+		Price int      `json:"price"`
+	}
+	items, err := NewTypedTree([]item{{"pen", []string{"office"}, 2}, {"cup", nil, 5}}, JSONEncoder[item](), SHA256Hasher)
+	require.NoError(t, err)
+	expected, err = NewMerkleTree(newLeaves([][]byte{
+		[]byte(`{"name":"pen","tags":["office"],"price":2}`),
+		[]byte(`{"name":"cup","tags":null,"price":5}`),
+	}), SHA256Hasher)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Hash(), items.Hash())
+
+	_, err = NewTypedTree([]string{}, StringEncoder, SHA256Hasher)
+	assert.ErrorIs(t, err, ErrEmptyTree)
+	_, err = NewTypedTree([]string{"not fixed-size"}, BinaryEncoder[string](), SHA256Hasher)
+	assert.Error(t, err)
+	_, err = NewTypedTree([]func(){nil}, JSONEncoder[func()](), SHA256Hasher)
+	assert.Error(t, err)
+}